@@ -1,51 +1,265 @@
 package main
 
 import (
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 )
 
-// SaveBlock : Save Blockchain in a JSON
-func SaveBlock(newBlock *Block, userID string) {
+// dataDir : Directory of Local Database, wallets and address books. It could be changed by Config.
+var dataDir = "./database"
 
-	dbPath := "./database/blocks_" + userID
-	os.MkdirAll("./database", os.ModePerm)
+// dataPath : Path of a file in dataDir
+func dataPath(name string) string {
+	return filepath.Join(dataDir, name)
+}
+
+// BlockStore : Storage of blocks used by Blockchain. Blocks are either in main chain, or in side branches.
+type BlockStore interface {
+	// AppendBlock : Store the block and its undo data, then append it to main chain
+	AppendBlock(block *Block, undo *UndoData) error
+	// RemoveLastBlock : Remove the last block from main chain, keep it as a side block
+	RemoveLastBlock() (*Block, error)
+	// SaveSideBlock : Store a block which is not in main chain
+	SaveSideBlock(block *Block) error
+	// MainChain / SideBlocks : Return blocks in main chain (Genesis Block first) / in side branches
+	MainChain() []*Block
+	SideBlocks() []*Block
+	// GetBlock / GetBlockByHeight / GetBlockByRoot : Lookup a block using the index
+	GetBlock(blockHash []byte) *Block
+	GetBlockByHeight(height int) *Block
+	GetBlockByRoot(root []byte) *Block
+	// GetBlockByLeaf : Lookup the main chain block which contains a data by its leaf hash. Return the block and the index of the data.
+	GetBlockByLeaf(leafHash []byte) (*Block, int)
+	// LoadUndo : Undo data of a block in main chain, used to roll back UTXOSet in a reorganization
+	LoadUndo(blockHash []byte) *UndoData
+	// Height : Number of blocks in main chain
+	Height() int
+	Close() error
+}
 
-	chain := LoadChain(userID)
+// openStores : BlockStore is opened once per userID, and shared by all connections of this process
+var openStores = make(map[string]BlockStore)
+var openStoresMutex sync.Mutex
 
-	// Add new block to this chain (array of block), and replace the whole json with this updated chain
-	chain = append(chain, newBlock)
-	chainjson, _ := json.Marshal(chain)
+// GetBlockStore : Return the BlockStore of userID. Open it if it is not opened yet. Return the error if it could not be opened.
+func GetBlockStore(userID string) (BlockStore, error) {
 
-	// Write the JSON on disk
+	openStoresMutex.Lock()
+	defer openStoresMutex.Unlock()
 
-	jsonFile, err := os.Create(dbPath + ".json")
+	if store, ok := openStores[userID]; ok {
+		return store, nil
+	}
+	store, err := OpenLogStore(dataPath("store_" + userID))
 	if err != nil {
-		fmt.Println("Database:	Cannot write JSON,", err)
+		return nil, err
 	}
-	_ = ioutil.WriteFile(dbPath+".json", chainjson, os.ModePerm)
-	jsonFile.Close()
+	openStores[userID] = store
+	return store, nil
+}
 
+// SaveBlock : Append a block with its undo data to main chain in Block Store. Return the error if it could not be saved.
+func SaveBlock(newBlock *Block, undo *UndoData, userID string) error {
+	store, err := GetBlockStore(userID)
+	if err == nil {
+		err = store.AppendBlock(newBlock, undo)
+	}
+	if err != nil {
+		logln(logError, "Database:	Cannot save Block,", err)
+	}
+	return err
 }
 
-// LoadChain : Loan Blockchain from JSON
+// LoadChain : Load main chain from Block Store. Return an empty chain if Block Store could not be opened.
 func LoadChain(userID string) []*Block {
-	dbPath := "./database/blocks_" + userID
+	store, err := GetBlockStore(userID)
+	if err != nil {
+		logln(logError, "Database:	Cannot open Block Store,", err)
+		return []*Block{}
+	}
+	return store.MainChain()
+}
 
-	// Read JSON from disk.
-	jsonFile, err := os.Open(dbPath + ".json")
+// LoadBlock : Load a Block from Block Store by CurrBlockHash, either in main chain or side branches. Return nil if it is not found.
+func LoadBlock(blockHash []byte, userID string) *Block {
+	store, err := GetBlockStore(userID)
+	if err != nil {
+		logln(logError, "Database:	Cannot open Block Store,", err)
+		return nil
+	}
+	return store.GetBlock(blockHash)
+}
+
+// LoadBlockByLeaf : Load the main chain Block which contains a data by its leaf hash, and the index of the data. Return nil if it is not found.
+func LoadBlockByLeaf(leafHash []byte, userID string) (*Block, int) {
+	store, err := GetBlockStore(userID)
+	if err != nil {
+		logln(logError, "Database:	Cannot open Block Store,", err)
+		return nil, -1
+	}
+	return store.GetBlockByLeaf(leafHash)
+}
+
+// RemoveLastBlock : Remove the last Block of main chain in Block Store. Return the removed Block.
+//					 Used when rolling back the chain in a reorganization. The Block is kept as a side block.
+func RemoveLastBlock(userID string) *Block {
+	store, err := GetBlockStore(userID)
+	var lastBlock *Block
+	if err == nil {
+		lastBlock, err = store.RemoveLastBlock()
+	}
+	if err != nil {
+		logln(logError, "Database:	Cannot remove Block,", err)
+	}
+	return lastBlock
+}
+
+// SaveSideBlock : Save a Block which is not in the main chain, i.e. in a side branch, in Block Store
+func SaveSideBlock(newBlock *Block, userID string) {
+	store, err := GetBlockStore(userID)
+	if err == nil {
+		err = store.SaveSideBlock(newBlock)
+	}
+	if err != nil {
+		logln(logError, "Database:	Cannot save Block,", err)
+	}
+}
+
+// LoadUndo : Load the undo data of a Block from Block Store
+func LoadUndo(blockHash []byte, userID string) *UndoData {
+	store, err := GetBlockStore(userID)
+	if err != nil {
+		logln(logError, "Database:	Cannot open Block Store,", err)
+		return nil
+	}
+	return store.LoadUndo(blockHash)
+}
+
+// LoadSideBlocks : Load Blocks of side branches from Block Store
+func LoadSideBlocks(userID string) []*Block {
+	store, err := GetBlockStore(userID)
 	if err != nil {
+		logln(logError, "Database:	Cannot open Block Store,", err)
 		return []*Block{}
 	}
-	jsonReader, _ := ioutil.ReadAll(jsonFile)
-	jsonFile.Close()
+	return store.SideBlocks()
+}
+
+// MigrateJSON : Import the JSON database of previous version, i.e. <dataDir>/blocks_<userID>.json & <dataDir>/sides_<userID>.json,
+//				 into Block Store. The JSON files are renamed to *.json.migrated afterwards.
+//				 Only JSON databases with Bits and coinbase, i.e. written by the version just before Block Store, are accepted.
+//				 Blocks of the first version (no Bits, no coinbase, 8-byte MagicNumber) are on a different chain and cannot be converted,
+//				 errLegacyJSON is returned for them.
+//				 Every block is checked as a block from peers (CheckBlock, CheckBlockContext and UTXO set) before anything is imported.
+//				 Return the error of the first invalid block, and nothing is imported.
+func MigrateJSON(userID string) error {
+
+	store, err := GetBlockStore(userID)
+	if err != nil {
+		return err
+	}
+	if store.Height() > 0 {
+		logln(logInfo, "Database:	Block Store is not empty. Skip migration.")
+		return nil
+	}
+
+	// Read JSON from disk.
+	chain, err := loadJSON(dataPath("blocks_" + userID + ".json"))
+	if err != nil {
+		return err
+	}
+	sides, err := loadJSON(dataPath("sides_" + userID + ".json"))
+	if err != nil {
+		return err
+	}
+	logf(logInfo, "Database:	%d block(s) in main chain, %d block(s) in side branches are found in JSON.\n", len(chain), len(sides))
+	for _, block := range append(append([]*Block{}, chain...), sides...) {
+		if isLegacyJSONBlock(block) {
+			return errLegacyJSON
+		}
+	}
+
+	// Check main chain in order using a new UTXO set, then side branches
+	utxo := &UTXOSet{Outputs: make(map[string]*UTXOEntry)}
+	undos := make([]*UndoData, len(chain))
+	for i := 0; i < len(chain); i++ {
+		if chain[i] == nil {
+			return fmt.Errorf("block #%d in main chain is empty", i)
+		}
+		err = chain[i].CheckBlock()
+		if err == nil {
+			err = CheckBlockContext(chain[i], chain, i)
+		}
+		if err == nil {
+			undos[i], err = utxo.ApplyBlock(chain[i], i)
+		}
+		if err != nil {
+			return fmt.Errorf("block #%d in main chain is invalid, %s", i, err)
+		}
+	}
+	for i := 0; i < len(sides); i++ {
+		if sides[i] == nil {
+			return fmt.Errorf("block #%d in side branches is empty", i)
+		}
+		if err = sides[i].CheckBlock(); err != nil {
+			return fmt.Errorf("block #%d in side branches is invalid, %s", i, err)
+		}
+	}
+
+	// Import main chain in order, then side branches
+	for i := 0; i < len(chain); i++ {
+		if err = store.AppendBlock(chain[i], undos[i]); err != nil {
+			return err
+		}
+	}
+	for i := 0; i < len(sides); i++ {
+		if err = store.SaveSideBlock(sides[i]); err != nil {
+			return err
+		}
+	}
+
+	os.Rename(dataPath("blocks_"+userID+".json"), dataPath("blocks_"+userID+".json.migrated"))
+	os.Rename(dataPath("sides_"+userID+".json"), dataPath("sides_"+userID+".json.migrated"))
+	logln(logInfo, "Database:	Migration is done.")
+	return nil
+}
+
+// errLegacyJSON : JSON database of the first version, which is on a chain without Bits and coinbase
+var errLegacyJSON = errors.New("JSON database is written by the first version (no Bits, no coinbase), which cannot be migrated. Move it away to start a new chain")
+
+// legacyMagicNumber : MagicNumber of the first version, the Block Header of the first version starts with it
+const legacyMagicNumber = "00004B61726C4E67"
+
+// isLegacyJSONBlock : Check if a block in JSON is written by the first version, i.e. it has no Bits, or its ByteStream starts with the old MagicNumber
+func isLegacyJSONBlock(block *Block) bool {
+	if block == nil {
+		return false
+	}
+	return block.Bits == 0 || (len(block.ByteStream) >= 8 && hex.EncodeToString(block.ByteStream[0:8]) == strings.ToLower(legacyMagicNumber))
+}
+
+// loadJSON : Load an array of blocks from JSON. Return an empty array if the file does not exist.
+func loadJSON(jsonPath string) ([]*Block, error) {
+
+	jsonReader, err := ioutil.ReadFile(jsonPath)
+	if os.IsNotExist(err) {
+		return []*Block{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
 
 	// Convert JSON to a chain (array of block)
 	var chain []*Block
-	err = json.Unmarshal(jsonReader, &chain)
-
-	// Return chain
-	return chain
+	if err = json.Unmarshal(jsonReader, &chain); err != nil {
+		return nil, fmt.Errorf("cannot read %s, %s", jsonPath, err)
+	}
+	return chain, nil
 }
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMigrateJSONLegacy(t *testing.T) {
+
+	// testdata/blocks_legacy.json : Two blocks written by the first version, i.e. no Bits, no coinbase and 8-byte MagicNumber
+	fixture, err := ioutil.ReadFile(filepath.Join("testdata", "blocks_legacy.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldDataDir := dataDir
+	dataDir = t.TempDir()
+	defer func() { dataDir = oldDataDir }()
+	if err = ioutil.WriteFile(dataPath("blocks_legacy.json"), fixture, 0644); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		openStoresMutex.Lock()
+		if store, ok := openStores["legacy"]; ok {
+			store.Close()
+			delete(openStores, "legacy")
+		}
+		openStoresMutex.Unlock()
+	}()
+
+	if err = MigrateJSON("legacy"); err != errLegacyJSON {
+		t.Fatalf("MigrateJSON returns %v, expected errLegacyJSON", err)
+	}
+	if _, err = os.Stat(dataPath("blocks_legacy.json")); err != nil {
+		t.Errorf("JSON database is renamed after a failed migration, %s", err)
+	}
+	if chain := LoadChain("legacy"); len(chain) != 0 {
+		t.Errorf("%d block(s) are imported after a failed migration", len(chain))
+	}
+
+	// A damaged JSON database is an error, not an empty chain
+	if err = ioutil.WriteFile(dataPath("blocks_legacy.json"), fixture[:len(fixture)/2], 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err = MigrateJSON("legacy"); !matchError(err, "cannot read") {
+		t.Errorf("MigrateJSON returns %v for a damaged JSON", err)
+	}
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+)
+
+// HeadersRequest : Payload of "getheaders". Peer replies headers of its main chain after the latest block found in Locator.
+type HeadersRequest struct {
+	// Hashes of blocks in the main chain of requester, from the tip back to Genesis Block. See CalLocator.
+	Locator [][]byte
+}
+
+// maxHeadersPerMsg : Maximum number of headers in a "getheaders" reply. Requester asks again if the reply is full.
+const maxHeadersPerMsg = 2000
+
+// bodyBatchSize : Number of blocks requested in one "getdt" when downloading data of new blocks
+const bodyBatchSize = 16
+
+// bodyFetchWorkers : Number of batches downloaded in parallel
+const bodyFetchWorkers = 4
+
+// CalLocator : Block locator of a chain. i.e. hashes of the last 10 blocks, then the step doubles back to Genesis Block.
+//
+//	Locator is short even if the chain is long, and a peer could still find the latest common block with it.
+func CalLocator(blocks []*Block) [][]byte {
+	var locator [][]byte
+	step := 1
+	for i := len(blocks) - 1; i >= 0; i = i - step {
+		locator = append(locator, blocks[i].CurrBlockHash)
+		if len(locator) >= 10 {
+			step = step * 2
+		}
+	}
+	if len(blocks) > 0 && string(locator[len(locator)-1]) != string(blocks[0].CurrBlockHash) {
+		locator = append(locator, blocks[0].CurrBlockHash)
+	}
+	return locator
+}
+
+// FindLocator : Return the height of the first block in locator which is in blocks. Return -1 if none is found.
+func FindLocator(blocks []*Block, locator [][]byte) int {
+	heights := make(map[string]int, len(blocks))
+	for i := 0; i < len(blocks); i++ {
+		heights[string(blocks[i].CurrBlockHash)] = i
+	}
+	for i := 0; i < len(locator); i++ {
+		if height, ok := heights[string(locator[i])]; ok {
+			return height
+		}
+	}
+	return -1
+}
+
+// LoadFromPeers : Load Blockchain of a peer, i.e. the common part of local Blockchain, then the blocks of the peer after it.
+//
+//	Step 1 : Download headers after the common ancestor with "getheaders", and check PoW and linkage of every header.
+//	Step 2 : Download data of the new blocks with "getdt" in parallel. Blocks whose data is not found are kept header only.
+//	Headers are downloaded from every peer, and the chain with the most work is kept, so that a peer behind others does not stop synchronization.
+//	If no peer is reachable and Local Database is empty, start from Genesis Block.
+func (bc *Blockchain) LoadFromPeers(userID string, local []*Block) {
+
+	bc.UserID = userID
+	pm := GetPeerManager(userID)
+	peers := pm.Peers()
+	var bestPeer string
+	var bestFork int
+	for i := 0; i < len(peers); i++ {
+		chain, fork, err := downloadHeaders(pm, peers[i], local)
+		if err != nil {
+			logf(logError, "Chain:	Cannot read headers from %s, %s\n", peers[i], err)
+			continue
+		}
+		if bc.Blocks == nil || CalChainWork(chain).Cmp(CalChainWork(bc.Blocks)) > 0 {
+			bc.Blocks = chain
+			bestPeer = peers[i]
+			bestFork = fork
+		}
+	}
+	if len(bc.Blocks) > 0 {
+		if len(bc.Blocks) > bestFork {
+			downloadBodies(pm, bc.Blocks[bestFork:])
+			logf(logInfo, "Chain:	%d new block(s) from %s\n", len(bc.Blocks)-bestFork, bestPeer)
+		}
+		return
+	}
+
+	// No peer is reachable. If Local Database is empty, start from Genesis Block, which is the same in every node.
+	logln(logError, "Chain:	No peer is reachable. Use Local Database only.")
+	if len(local) == 0 {
+		bc.Blocks = []*Block{CreateGenesisBlock()}
+	}
+}
+
+// downloadHeaders :	Download headers of the main chain of a peer, after the common ancestor with local.
+//						Return the chain of the peer (blocks of local up to the common ancestor, then headers of the peer),
+//						and the length of the common part. The peer is banned if a header is invalid.
+func downloadHeaders(pm *PeerManager, addr string, local []*Block) ([]*Block, int, error) {
+
+	chain := make([]*Block, len(local))
+	copy(chain, local)
+	fork := len(local)
+	for {
+		// Step 1 : Request headers after the latest block in locator
+		request, _ := json.Marshal(&HeadersRequest{Locator: CalLocator(chain)})
+		reply, err := pm.SendMsg(addr, "getheaders", request)
+		if err != nil {
+			return nil, 0, err
+		}
+		var headers []*Block
+		if err = json.Unmarshal(reply, &headers); err != nil {
+			pm.Misbehave(addr, 20, "invalid headers")
+			return nil, 0, err
+		}
+		if len(headers) == 0 {
+			return chain, fork, nil
+		}
+
+		// Step 2 : Find the parent of the first header. Genesis Block has no parent.
+		parent := -1
+		if !isGenesisBlock(headers[0]) {
+			parent = FindLocator(chain, [][]byte{headers[0].PrevBlockHash})
+			if parent < 0 {
+				pm.Misbehave(addr, 20, "unconnected headers")
+				return nil, 0, fmt.Errorf("headers are not connected")
+			}
+		}
+		chain = chain[:parent+1]
+		if parent+1 < fork {
+			fork = parent + 1
+		}
+
+		// Step 3 : Check Proof of Work, linkage, Bits and Timestamp of every header.
+		//			A header too far in the future may be caused by the clock of this node, so the peer is not banned for it.
+		for i := 0; i < len(headers); i++ {
+			header := headers[i].CopyHeader()
+			err := header.CheckHeader()
+			if err == nil {
+				err = CheckBlockContext(header, chain, len(chain))
+			}
+			if err != nil {
+				if blockErr, ok := err.(*BlockError); !ok || blockErr.Reason != RejectTimeTooNew {
+					pm.Misbehave(addr, peerBanScore, "invalid header, "+err.Error())
+				}
+				return nil, 0, fmt.Errorf("header %x is invalid, %s", header.CurrBlockHash, err)
+			}
+			chain = append(chain, header)
+		}
+		if len(headers) < maxHeadersPerMsg {
+			return chain, fork, nil
+		}
+	}
+}
+
+// downloadBodies : Download data of blocks with "getdt" from peers in parallel, and fill them into blocks.
+//					Data is checked against the header, i.e. size, coinbase, Merkle Tree Root and signatures. Blocks whose data is not found are kept header only.
+func downloadBodies(pm *PeerManager, blocks []*Block) {
+
+	peers := pm.Peers()
+	if len(peers) == 0 {
+		return
+	}
+	batches := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < bodyFetchWorkers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for start := range batches {
+				end := start + bodyBatchSize
+				if end > len(blocks) {
+					end = len(blocks)
+				}
+				// Ask peers one by one, starting from a different peer in each worker
+				for p := 0; p < len(peers); p++ {
+					if downloadBatch(pm, peers[(w+p)%len(peers)], blocks[start:end]) {
+						break
+					}
+				}
+			}
+		}(w)
+	}
+	for start := 0; start < len(blocks); start = start + bodyBatchSize {
+		batches <- start
+	}
+	close(batches)
+	wg.Wait()
+}
+
+// downloadBatch : Download data of a batch of blocks from a peer. Return true if data of every block is found.
+func downloadBatch(pm *PeerManager, addr string, blocks []*Block) bool {
+
+	var hashes [][]byte
+	for i := 0; i < len(blocks); i++ {
+		if len(blocks[i].Data) == 0 {
+			hashes = append(hashes, blocks[i].CurrBlockHash)
+		}
+	}
+	if len(hashes) == 0 {
+		return true
+	}
+	request, _ := json.Marshal(&InvMessage{From: pm.Self(), Type: "block", Hashes: hashes})
+	reply, err := pm.SendMsg(addr, "getdt", request)
+	if err != nil {
+		return false
+	}
+	var fullBlocks []*Block
+	json.Unmarshal(reply, &fullBlocks)
+
+	// Data must match the header, i.e. the same hash and the same Merkle Tree Root
+	found := make(map[string]*Block)
+	for i := 0; i < len(fullBlocks); i++ {
+		if fullBlocks[i] != nil {
+			found[string(fullBlocks[i].CurrBlockHash)] = fullBlocks[i]
+		}
+	}
+	allFlag := true
+	for i := 0; i < len(blocks); i++ {
+		fullBlock := found[string(blocks[i].CurrBlockHash)]
+		if len(blocks[i].Data) > 0 {
+			continue
+		}
+		if fullBlock == nil {
+			allFlag = false
+			continue
+		}
+		blocks[i].Data = fullBlock.Data
+		blocks[i].ExtraNonce = fullBlock.ExtraNonce
+		if err := blocks[i].CheckBlock(); err != nil {
+			pm.Misbehave(addr, peerBanScore, "invalid block data, "+err.Error())
+			blocks[i].Data = nil
+			blocks[i].ExtraNonce = 0
+			return false
+		}
+	}
+	return allFlag
+}
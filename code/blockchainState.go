@@ -0,0 +1,91 @@
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ChainState : Blockchain of a node, kept in memory for the whole life of the node and shared by all connection handlers.
+//
+//	Readers take a Snapshot, i.e. a copy of the main chain, without touching the disk.
+//	Writers (adding blocks, synchronization with peers) are queued to one writer goroutine, so that they never run
+//	at the same time and Local Database could not be corrupted by concurrent requests.
+type ChainState struct {
+	mutex  sync.RWMutex
+	chain  Blockchain
+	writes chan func()
+	// syncing is 1 when Sync is running
+	syncing int32
+}
+
+// NewChainState : Load Blockchain of userID (synchronized with peers), then start the writer goroutine
+func NewChainState(userID string) *ChainState {
+
+	cs := &ChainState{writes: make(chan func())}
+	cs.chain.LoadFromDB(userID)
+	go cs.writer()
+	return cs
+}
+
+// writer : Run write requests one by one
+func (cs *ChainState) writer() {
+	for write := range cs.writes {
+		write()
+	}
+}
+
+// Snapshot : Return a copy of Blockchain. Blocks are shared, the array is not, so later writes do not change the copy.
+//			  BlockTree is shared too, only the writer goroutine uses it (see Update).
+func (cs *ChainState) Snapshot() Blockchain {
+	cs.mutex.RLock()
+	defer cs.mutex.RUnlock()
+
+	blocks := make([]*Block, len(cs.chain.Blocks))
+	copy(blocks, cs.chain.Blocks)
+	return Blockchain{UserID: cs.chain.UserID, Blocks: blocks, tree: cs.chain.tree}
+}
+
+// Update : Run fn with a copy of Blockchain in the writer goroutine, then publish the copy to readers.
+//			fn keeps the copy the same as the main chain in Local Database, e.g. ConnectBlock and Reorganize change both.
+//			Return after fn is done. fn must not call Update, otherwise it waits for itself forever.
+func (cs *ChainState) Update(fn func(bc *Blockchain)) {
+
+	done := make(chan struct{})
+	cs.writes <- func() {
+		defer close(done)
+		bc := cs.Snapshot()
+		fn(&bc)
+
+		cs.mutex.Lock()
+		cs.chain = bc
+		cs.mutex.Unlock()
+	}
+	<-done
+}
+
+// Sync :	Synchronize with peers. Headers and data are downloaded outside the writer goroutine, so that slow peers do not block other writes.
+//			Only connecting the downloaded chain runs in the writer goroutine (see ConnectPeerChain).
+//			Return at once if another synchronization is running.
+func (cs *ChainState) Sync() {
+
+	if !atomic.CompareAndSwapInt32(&cs.syncing, 0, 1) {
+		return
+	}
+	defer atomic.StoreInt32(&cs.syncing, 0)
+
+	local := cs.Snapshot()
+	var bcPeer Blockchain
+	bcPeer.LoadFromPeers(local.UserID, local.Blocks)
+	cs.Update(func(bc *Blockchain) {
+		bc.ConnectPeerChain(bcPeer.Blocks)
+	})
+}
+
+// SyncLoop : Synchronize with peers every syncInterval
+func (cs *ChainState) SyncLoop() {
+	for {
+		time.Sleep(syncInterval * time.Second)
+		cs.Sync()
+	}
+}
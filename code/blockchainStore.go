@@ -0,0 +1,540 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// LogStore : Append-only, crash-safe implementation of BlockStore.
+//
+//	Records are appended to log segments <dataDir>/store_<userID>/segment_<n>.log, a new segment is started when a segment is full.
+//	Record is defined as
+//	4	bytes:	Length			(10-digit decimal positive integer, length of Type + Payload)
+//	4	bytes:	Checksum		(CRC-32 of Type + Payload)
+//	1	byte :	Type			(recBlock, recConnect, recDisconnect or recUndo)
+//	Variable :	Payload			(JSON of Block for recBlock, CurrBlockHash for recConnect, empty for recDisconnect,
+//								 CurrBlockHash + JSON of UndoData for recUndo)
+//
+// Every record is synced to disk before returning. When the store is opened, segments are replayed to rebuild the index.
+// A torn record at the end of the last segment, i.e. process died mid-write, is truncated.
+type LogStore struct {
+	mutex   sync.Mutex
+	dir     string
+	segment *os.File
+	segNum  int
+	segSize int64
+
+	// Index by CurrBlockHash, height in main chain and Merkle Tree Root of main chain blocks
+	byHash   map[string]*recordLocation
+	byHeight []string
+	byRoot   map[string]string
+	// Height of main chain blocks by CurrBlockHash, i.e. the reverse of byHeight
+	heights map[string]int
+	// Positions of data in main chain blocks by leaf hash, i.e. CalSHA256Hash of the data, in order of height.
+	// Header only blocks are not indexed until their data is stored.
+	byLeaf map[string][]*leafLocation
+	// Index of undo data by CurrBlockHash
+	undoByHash map[string]*recordLocation
+}
+
+// leafLocation : Position of a data in main chain, i.e. CurrBlockHash of the block and index in Block.Data
+type leafLocation struct {
+	blockHash string
+	index     int
+}
+
+// recordLocation : Position of a recBlock record on disk
+type recordLocation struct {
+	segNum int
+	offset int64
+	length int
+}
+
+const recBlock = 1
+const recConnect = 2
+const recDisconnect = 3
+const recUndo = 4
+
+const recHeaderLen = 9
+const segmentMaxSize = 16 * 1024 * 1024
+
+// recordMaxLen : Upper bound of Length of a record, i.e. Type + Payload. A larger Length in a segment is a corrupted record.
+const recordMaxLen = 64 * 1024 * 1024
+
+var errStoreEmpty = errors.New("main chain is empty")
+var errRecordTooLarge = errors.New("record is too large")
+
+// OpenLogStore : Open (or create) a LogStore in dir, and rebuild its index by replaying all segments
+func OpenLogStore(dir string) (*LogStore, error) {
+
+	err := os.MkdirAll(dir, os.ModePerm)
+	if err != nil {
+		return nil, err
+	}
+	ls := &LogStore{
+		dir:    dir,
+		byHash: make(map[string]*recordLocation),
+		byRoot: make(map[string]string),
+
+		heights:    make(map[string]int),
+		byLeaf:     make(map[string][]*leafLocation),
+		undoByHash: make(map[string]*recordLocation),
+	}
+
+	// Replay segments in order
+	segments, _ := filepath.Glob(filepath.Join(dir, "segment_*.log"))
+	sort.Strings(segments)
+	for i := 0; i < len(segments); i++ {
+		ls.segNum = i + 1
+		if err = ls.replaySegment(i == len(segments)-1); err != nil {
+			return nil, err
+		}
+	}
+
+	// Open the last segment for appending
+	if ls.segNum == 0 {
+		ls.segNum = 1
+	}
+	return ls, ls.openSegment()
+}
+
+// segmentPath : File name of segment n
+func (ls *LogStore) segmentPath(n int) string {
+	return filepath.Join(ls.dir, fmt.Sprintf("segment_%06d.log", n))
+}
+
+// openSegment : Open current segment for appending
+func (ls *LogStore) openSegment() error {
+	file, err := os.OpenFile(ls.segmentPath(ls.segNum), os.O_CREATE|os.O_RDWR|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	ls.segment = file
+	ls.segSize = info.Size()
+	return nil
+}
+
+// replaySegment : Read every record in current segment and apply it to the index.
+//
+//	If lastFlag is ON, a torn record at the end is truncated instead of returning an error.
+func (ls *LogStore) replaySegment(lastFlag bool) error {
+
+	file, err := os.OpenFile(ls.segmentPath(ls.segNum), os.O_RDWR, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var offset int64
+	header := make([]byte, 8)
+	for {
+		// Step 1 : Read record header
+		_, err = io.ReadFull(file, header)
+		if err == io.EOF {
+			return nil
+		}
+		length := binary.BigEndian.Uint32(header[0:4])
+		var body []byte
+		if err == nil && length > 0 && length <= recordMaxLen {
+			body = make([]byte, length)
+			_, err = io.ReadFull(file, body)
+		} else if err == nil {
+			err = io.ErrUnexpectedEOF
+		}
+
+		// Step 2 : Torn or corrupted record. Truncate if this is the last segment.
+		if err != nil || crc32.ChecksumIEEE(body) != binary.BigEndian.Uint32(header[4:8]) {
+			if !lastFlag {
+				return fmt.Errorf("corrupted record in %s at offset %d", ls.segmentPath(ls.segNum), offset)
+			}
+			logf(logError, "Database:	Truncate torn record in %s at offset %d\n", ls.segmentPath(ls.segNum), offset)
+			return file.Truncate(offset)
+		}
+
+		// Step 3 : Apply to index
+		ls.applyRecord(body[0], body[1:], &recordLocation{segNum: ls.segNum, offset: offset + 8 + 1, length: len(body) - 1})
+		offset = offset + 8 + int64(length)
+	}
+}
+
+// applyRecord : Update the index using a record
+func (ls *LogStore) applyRecord(recType byte, payload []byte, location *recordLocation) {
+	switch recType {
+	case recBlock:
+		var block *Block
+		if json.Unmarshal(payload, &block) == nil && block != nil {
+			ls.byHash[string(block.CurrBlockHash)] = location
+			// Data of a header only block in main chain is stored
+			if _, ok := ls.heights[string(block.CurrBlockHash)]; ok {
+				ls.indexLeaves(block, true)
+			}
+		}
+	case recConnect:
+		ls.heights[string(payload)] = len(ls.byHeight)
+		ls.byHeight = append(ls.byHeight, string(payload))
+		if block, err := ls.readBlock(payload); err != nil {
+			logln(logError, "Database:	Failed to read block.", err)
+		} else if block != nil {
+			ls.byRoot[string(block.Root)] = string(payload)
+			ls.indexLeaves(block, true)
+		}
+	case recUndo:
+		if len(payload) >= 32 && location != nil {
+			ls.undoByHash[string(payload[0:32])] = &recordLocation{segNum: location.segNum, offset: location.offset + 32, length: location.length - 32}
+		}
+	case recDisconnect:
+		if len(ls.byHeight) > 0 {
+			if block, err := ls.readBlock([]byte(ls.byHeight[len(ls.byHeight)-1])); err != nil {
+				logln(logError, "Database:	Failed to read block.", err)
+			} else if block != nil {
+				// Another block with the same Root may be indexed, keep it
+				if ls.byRoot[string(block.Root)] == string(block.CurrBlockHash) {
+					delete(ls.byRoot, string(block.Root))
+				}
+				ls.indexLeaves(block, false)
+			}
+			delete(ls.heights, ls.byHeight[len(ls.byHeight)-1])
+			ls.byHeight = ls.byHeight[:len(ls.byHeight)-1]
+		}
+	}
+}
+
+// indexLeaves : Add (addFlag is ON) or remove the data of a main chain block in the leaf index.
+//				 If the same data is in more than one block, every position is kept, so that removing one block keeps the others.
+func (ls *LogStore) indexLeaves(block *Block, addFlag bool) {
+	var n Node
+	for i := 0; i < len(block.Data); i++ {
+		leafHash := string(n.CalSHA256Hash(block.Data[i]))
+		if addFlag {
+			// Keep the positions in order of height, data of a header only block may be stored after later blocks
+			locations := ls.byLeaf[leafHash]
+			j := len(locations)
+			for j > 0 && ls.heights[locations[j-1].blockHash] > ls.heights[string(block.CurrBlockHash)] {
+				j--
+			}
+			locations = append(locations, nil)
+			copy(locations[j+1:], locations[j:])
+			locations[j] = &leafLocation{blockHash: string(block.CurrBlockHash), index: i}
+			ls.byLeaf[leafHash] = locations
+			continue
+		}
+		// Remove only the positions in this block
+		var kept []*leafLocation
+		for _, location := range ls.byLeaf[leafHash] {
+			if location.blockHash != string(block.CurrBlockHash) {
+				kept = append(kept, location)
+			}
+		}
+		if len(kept) == 0 {
+			delete(ls.byLeaf, leafHash)
+		} else {
+			ls.byLeaf[leafHash] = kept
+		}
+	}
+}
+
+// appendRecord : Append a record to current segment and sync it to disk. Start a new segment if current segment is full.
+//				  If the record could not be written, the segment is truncated back to its previous size, so no torn record is left before later records.
+func (ls *LogStore) appendRecord(recType byte, payload []byte) (*recordLocation, error) {
+
+	if 1+len(payload) > recordMaxLen {
+		return nil, errRecordTooLarge
+	}
+	if ls.segSize+int64(recHeaderLen+len(payload)) > segmentMaxSize && ls.segSize > 0 {
+		ls.segment.Close()
+		ls.segNum = ls.segNum + 1
+		if err := ls.openSegment(); err != nil {
+			return nil, err
+		}
+	}
+
+	body := append([]byte{recType}, payload...)
+	record := make([]byte, 8, 8+len(body))
+	binary.BigEndian.PutUint32(record[0:4], uint32(len(body)))
+	binary.BigEndian.PutUint32(record[4:8], crc32.ChecksumIEEE(body))
+	record = append(record, body...)
+
+	_, err := ls.segment.Write(record)
+	if err == nil {
+		err = ls.segment.Sync()
+	}
+	if err != nil {
+		if truncErr := ls.segment.Truncate(ls.segSize); truncErr != nil {
+			logln(logError, "Database:	Failed to truncate segment.", truncErr)
+		}
+		return nil, err
+	}
+
+	location := &recordLocation{segNum: ls.segNum, offset: ls.segSize + recHeaderLen, length: len(payload)}
+	ls.segSize = ls.segSize + int64(len(record))
+	return location, nil
+}
+
+// readBlock : Read a block from disk using the hash index. Height is set by the height index, not the stored value.
+//			   Return nil and no error if the block is not stored.
+func (ls *LogStore) readBlock(blockHash []byte) (*Block, error) {
+
+	location := ls.byHash[string(blockHash)]
+	if location == nil {
+		return nil, nil
+	}
+	payload, err := ls.readPayload(location)
+	if err != nil {
+		return nil, err
+	}
+	var block *Block
+	if err = json.Unmarshal(payload, &block); err != nil {
+		return nil, fmt.Errorf("block %x: %v", blockHash, err)
+	}
+	if block == nil {
+		return nil, fmt.Errorf("block %x: empty record", blockHash)
+	}
+	block.Height = -1
+	if height, ok := ls.heights[string(blockHash)]; ok {
+		block.Height = height
+	}
+	return block, nil
+}
+
+// getBlock : Read a block like readBlock, but log the error and return nil. Used by the getters which return only the block.
+func (ls *LogStore) getBlock(blockHash []byte) *Block {
+	block, err := ls.readBlock(blockHash)
+	if err != nil {
+		logln(logError, "Database:	Failed to read block.", err)
+	}
+	return block
+}
+
+// readPayload : Read the payload of a record from disk
+func (ls *LogStore) readPayload(location *recordLocation) ([]byte, error) {
+
+	file, err := os.Open(ls.segmentPath(location.segNum))
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	payload := make([]byte, location.length)
+	if _, err = file.ReadAt(payload, location.offset); err != nil {
+		return nil, err
+	}
+	return payload, nil
+}
+
+// putBlock : Store the block body once. A stored header-only block is replaced if the full block is provided.
+//			  Return an error if the stored block could not be read, e.g. the segment is removed.
+func (ls *LogStore) putBlock(block *Block) error {
+	if ls.byHash[string(block.CurrBlockHash)] != nil {
+		storedBlock, err := ls.readBlock(block.CurrBlockHash)
+		if err != nil {
+			return err
+		}
+		if len(block.Data) == 0 || len(storedBlock.Data) > 0 {
+			return nil
+		}
+	}
+	blockJSON, _ := json.Marshal(block)
+	location, err := ls.appendRecord(recBlock, blockJSON)
+	if err != nil {
+		return err
+	}
+	ls.byHash[string(block.CurrBlockHash)] = location
+	if _, ok := ls.heights[string(block.CurrBlockHash)]; ok {
+		ls.indexLeaves(block, true)
+	}
+	return nil
+}
+
+// AppendBlock : Store the block and its undo data, then append it to main chain.
+//				 Undo data is written before the connect record, so a block in main chain always has its undo data after a crash.
+func (ls *LogStore) AppendBlock(block *Block, undo *UndoData) error {
+	ls.mutex.Lock()
+	defer ls.mutex.Unlock()
+
+	if err := ls.putBlock(block); err != nil {
+		return err
+	}
+	if undo != nil {
+		if err := ls.putUndo(block.CurrBlockHash, undo); err != nil {
+			return err
+		}
+	}
+	if _, err := ls.appendRecord(recConnect, block.CurrBlockHash); err != nil {
+		return err
+	}
+	ls.applyRecord(recConnect, block.CurrBlockHash, nil)
+	return nil
+}
+
+// RemoveLastBlock : Remove the last block from main chain. The block is still stored, i.e. it becomes a side block.
+func (ls *LogStore) RemoveLastBlock() (*Block, error) {
+	ls.mutex.Lock()
+	defer ls.mutex.Unlock()
+
+	if len(ls.byHeight) == 0 {
+		return nil, errStoreEmpty
+	}
+	lastBlock, err := ls.readBlock([]byte(ls.byHeight[len(ls.byHeight)-1]))
+	if err != nil {
+		return nil, err
+	}
+	if _, err = ls.appendRecord(recDisconnect, nil); err != nil {
+		return nil, err
+	}
+	ls.applyRecord(recDisconnect, nil, nil)
+	return lastBlock, nil
+}
+
+// SaveSideBlock : Store a block which is not in main chain
+func (ls *LogStore) SaveSideBlock(block *Block) error {
+	ls.mutex.Lock()
+	defer ls.mutex.Unlock()
+
+	return ls.putBlock(block)
+}
+
+// putUndo : Store the undo data of a block. Caller must hold the mutex.
+func (ls *LogStore) putUndo(blockHash []byte, undo *UndoData) error {
+	undoJSON, _ := json.Marshal(undo)
+	payload := append(append([]byte{}, blockHash...), undoJSON...)
+	location, err := ls.appendRecord(recUndo, payload)
+	if err != nil {
+		return err
+	}
+	ls.applyRecord(recUndo, payload, location)
+	return nil
+}
+
+// LoadUndo : Return the undo data of a block. Return nil if it is not stored.
+func (ls *LogStore) LoadUndo(blockHash []byte) *UndoData {
+	ls.mutex.Lock()
+	defer ls.mutex.Unlock()
+
+	location := ls.undoByHash[string(blockHash)]
+	if location == nil {
+		return nil
+	}
+	payload, err := ls.readPayload(location)
+	var undo *UndoData
+	if err == nil {
+		err = json.Unmarshal(payload, &undo)
+	}
+	if err != nil {
+		logln(logError, "Database:	Failed to read undo data.", err)
+		return nil
+	}
+	return undo
+}
+
+// MainChain : Return all blocks in main chain, from Genesis Block to tip
+func (ls *LogStore) MainChain() []*Block {
+	ls.mutex.Lock()
+	defer ls.mutex.Unlock()
+
+	chain := []*Block{}
+	for i := 0; i < len(ls.byHeight); i++ {
+		if block := ls.getBlock([]byte(ls.byHeight[i])); block != nil {
+			chain = append(chain, block)
+		}
+	}
+	return chain
+}
+
+// SideBlocks : Return all stored blocks which are not in main chain
+func (ls *LogStore) SideBlocks() []*Block {
+	ls.mutex.Lock()
+	defer ls.mutex.Unlock()
+
+	mainFlag := make(map[string]bool)
+	for i := 0; i < len(ls.byHeight); i++ {
+		mainFlag[ls.byHeight[i]] = true
+	}
+	sides := []*Block{}
+	for hash := range ls.byHash {
+		if !mainFlag[hash] {
+			if block := ls.getBlock([]byte(hash)); block != nil {
+				sides = append(sides, block)
+			}
+		}
+	}
+	return sides
+}
+
+// GetBlock : Return a stored block by CurrBlockHash, either in main chain or side branches
+func (ls *LogStore) GetBlock(blockHash []byte) *Block {
+	ls.mutex.Lock()
+	defer ls.mutex.Unlock()
+
+	return ls.getBlock(blockHash)
+}
+
+// GetBlockByHeight : Return the block at height of main chain
+func (ls *LogStore) GetBlockByHeight(height int) *Block {
+	ls.mutex.Lock()
+	defer ls.mutex.Unlock()
+
+	if height < 0 || height >= len(ls.byHeight) {
+		return nil
+	}
+	return ls.getBlock([]byte(ls.byHeight[height]))
+}
+
+// GetBlockByRoot : Return the block of main chain with Merkle Tree Root
+func (ls *LogStore) GetBlockByRoot(root []byte) *Block {
+	ls.mutex.Lock()
+	defer ls.mutex.Unlock()
+
+	blockHash, ok := ls.byRoot[string(root)]
+	if !ok {
+		return nil
+	}
+	return ls.getBlock([]byte(blockHash))
+}
+
+// GetBlockByLeaf : Return the block of main chain which contains a data, and the index of the data in Block.Data.
+//					leafHash is CalSHA256Hash of the data. If the data is in more than one block, the lowest one is returned.
+//					Return nil and -1 if it is not found.
+func (ls *LogStore) GetBlockByLeaf(leafHash []byte) (*Block, int) {
+	ls.mutex.Lock()
+	defer ls.mutex.Unlock()
+
+	locations := ls.byLeaf[string(leafHash)]
+	if len(locations) == 0 {
+		return nil, -1
+	}
+	location := locations[0]
+	block := ls.getBlock([]byte(location.blockHash))
+	if block == nil || location.index >= len(block.Data) {
+		return nil, -1
+	}
+	return block, location.index
+}
+
+// Height : Return the number of blocks in main chain
+func (ls *LogStore) Height() int {
+	ls.mutex.Lock()
+	defer ls.mutex.Unlock()
+
+	return len(ls.byHeight)
+}
+
+// Close : Close current segment
+func (ls *LogStore) Close() error {
+	ls.mutex.Lock()
+	defer ls.mutex.Unlock()
+
+	return ls.segment.Close()
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// testStoreBlock : A block with hash and data, enough for LogStore which does not check blocks
+func testStoreBlock(hash byte, data ...string) *Block {
+	bk := &Block{CurrBlockHash: bytes.Repeat([]byte{hash}, 32), Root: bytes.Repeat([]byte{hash}, 32)}
+	for i := 0; i < len(data); i++ {
+		bk.Data = append(bk.Data, []byte(data[i]))
+	}
+	return bk
+}
+
+func TestLogStoreLeafIndex(t *testing.T) {
+
+	ls, err := OpenLogStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ls.Close()
+
+	// The same data is in two blocks of main chain. Data of the first block is stored after the second block.
+	if err := ls.AppendBlock(testStoreBlock(1).CopyHeader(), nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := ls.AppendBlock(testStoreBlock(2, "same", "b"), nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := ls.SaveSideBlock(testStoreBlock(1, "a", "same")); err != nil {
+		t.Fatal(err)
+	}
+	var n Node
+	leaf := n.CalSHA256Hash([]byte("same"))
+	if block, index := ls.GetBlockByLeaf(leaf); block == nil || block.CurrBlockHash[0] != 1 || index != 1 {
+		t.Fatalf("GetBlockByLeaf returns %v, %d, expected the first block", block, index)
+	}
+
+	// Disconnect the second block, the first one is still indexed
+	if _, err := ls.RemoveLastBlock(); err != nil {
+		t.Fatal(err)
+	}
+	if block, index := ls.GetBlockByLeaf(leaf); block == nil || block.CurrBlockHash[0] != 1 || index != 1 {
+		t.Errorf("GetBlockByLeaf after disconnect returns %v, %d, expected the first block", block, index)
+	}
+	if block, _ := ls.GetBlockByLeaf(n.CalSHA256Hash([]byte("b"))); block != nil {
+		t.Errorf("Data of a disconnected block is still indexed")
+	}
+
+	// Disconnect the first block, the data is not in main chain anymore
+	if _, err := ls.RemoveLastBlock(); err != nil {
+		t.Fatal(err)
+	}
+	if block, _ := ls.GetBlockByLeaf(leaf); block != nil {
+		t.Errorf("Data of disconnected blocks is still indexed")
+	}
+}
+
+// testStoreChain : Check heights of main chain in ls, i.e. CurrBlockHash[0] of every block
+func testStoreChain(t *testing.T, ls *LogStore, hashes ...byte) {
+	t.Helper()
+	chain := ls.MainChain()
+	if len(chain) != len(hashes) || ls.Height() != len(hashes) {
+		t.Fatalf("main chain has %d block(s), Height is %d, expected %d", len(chain), ls.Height(), len(hashes))
+	}
+	for i := 0; i < len(chain); i++ {
+		if chain[i].CurrBlockHash[0] != hashes[i] || chain[i].Height != i {
+			t.Errorf("block #%d is %x at height %d, expected %02x", i, chain[i].CurrBlockHash[:1], chain[i].Height, hashes[i])
+		}
+	}
+}
+
+func TestLogStoreReopen(t *testing.T) {
+
+	dir := t.TempDir()
+	ls, err := OpenLogStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	undo := &UndoData{Created: []*UTXOEntry{{TxHash: bytes.Repeat([]byte{2}, 32), Index: 0, Output: &TxOutput{Amount: 50}}}}
+	for _, bk := range []*Block{testStoreBlock(1, "a"), testStoreBlock(2, "b"), testStoreBlock(3, "c")} {
+		var bkUndo *UndoData
+		if bk.CurrBlockHash[0] == 2 {
+			bkUndo = undo
+		}
+		if err := ls.AppendBlock(bk, bkUndo); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := ls.RemoveLastBlock(); err != nil {
+		t.Fatal(err)
+	}
+	if err := ls.SaveSideBlock(testStoreBlock(4, "d")); err != nil {
+		t.Fatal(err)
+	}
+	ls.Close()
+
+	// Every index is rebuilt from the records
+	ls, err = OpenLogStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ls.Close()
+	testStoreChain(t, ls, 1, 2)
+	if sides := ls.SideBlocks(); len(sides) != 2 {
+		t.Errorf("%d side block(s) after reopen, expected 2", len(sides))
+	}
+	if block := ls.GetBlock(testStoreBlock(3).CurrBlockHash); block == nil || block.Height != -1 || len(block.Data) != 1 {
+		t.Errorf("disconnected block after reopen is %v", block)
+	}
+	if block := ls.GetBlockByRoot(testStoreBlock(2).Root); block == nil || block.CurrBlockHash[0] != 2 {
+		t.Errorf("GetBlockByRoot after reopen returns %v", block)
+	}
+	if got := ls.LoadUndo(testStoreBlock(2).CurrBlockHash); got == nil || len(got.Created) != 1 || got.Created[0].Output.Amount != 50 {
+		t.Errorf("LoadUndo after reopen returns %v", got)
+	}
+}
+
+func TestLogStoreTornRecord(t *testing.T) {
+
+	dir := t.TempDir()
+	ls, err := OpenLogStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, bk := range []*Block{testStoreBlock(1, "a"), testStoreBlock(2, "b")} {
+		if err := ls.AppendBlock(bk, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ls.Close()
+	segment := filepath.Join(dir, "segment_000001.log")
+	info, err := os.Stat(segment)
+	if err != nil {
+		t.Fatal(err)
+	}
+	size := info.Size()
+
+	tests := []struct {
+		name   string
+		damage func(data []byte) []byte
+		size   int64
+		hashes []byte
+	}{
+		// Crash while writing the header or the body of the next record
+		{"torn header", func(data []byte) []byte { return append(data, 0, 0, 0) }, size, []byte{1, 2}},
+		{"torn body", func(data []byte) []byte { return append(data, 0, 0, 0, 100, 1, 2, 3, 4, recBlock, '{') }, size, []byte{1, 2}},
+		// The last record, i.e. connect of block 2, does not match its checksum
+		{"bad checksum", func(data []byte) []byte { data[len(data)-1] ^= 0xff; return data }, size - recHeaderLen - 32, []byte{1}},
+	}
+	for _, test := range tests {
+		data, err := ioutil.ReadFile(segment)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = ioutil.WriteFile(segment, test.damage(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		// The torn record is truncated, and the store could be used again
+		ls, err = OpenLogStore(dir)
+		if err != nil {
+			t.Fatalf("%s: OpenLogStore returns %v", test.name, err)
+		}
+		if info, _ = os.Stat(segment); info.Size() != test.size {
+			t.Errorf("%s: segment is %d bytes after truncation, expected %d", test.name, info.Size(), test.size)
+		}
+		testStoreChain(t, ls, test.hashes...)
+		ls.Close()
+	}
+
+	// New records are appended after the truncated one
+	ls, err = OpenLogStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = ls.AppendBlock(testStoreBlock(3, "c"), nil); err != nil {
+		t.Fatal(err)
+	}
+	ls.Close()
+	ls, err = OpenLogStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ls.Close()
+	testStoreChain(t, ls, 1, 3)
+}
+
+func TestLogStoreCorruptedSegment(t *testing.T) {
+
+	dir := t.TempDir()
+	ls, err := OpenLogStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ls.AppendBlock(testStoreBlock(1, "a"), nil); err != nil {
+		t.Fatal(err)
+	}
+	ls.Close()
+
+	// Only the last segment could be torn by a crash, a damaged record in an earlier segment is an error
+	data, err := ioutil.ReadFile(filepath.Join(dir, "segment_000001.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[len(data)-1] ^= 0xff
+	if err = ioutil.WriteFile(filepath.Join(dir, "segment_000001.log"), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(filepath.Join(dir, "segment_000002.log"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = OpenLogStore(dir); !matchError(err, "corrupted record") {
+		t.Errorf("OpenLogStore returns %v", err)
+	}
+}
+
+func TestLogStoreUnreadableBlock(t *testing.T) {
+
+	dir := t.TempDir()
+	ls, err := OpenLogStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ls.Close()
+	if err := ls.AppendBlock(testStoreBlock(1).CopyHeader(), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// Damage the stored JSON after the record is indexed
+	location := ls.byHash[string(testStoreBlock(1).CurrBlockHash)]
+	file, err := os.OpenFile(filepath.Join(dir, "segment_000001.log"), os.O_RDWR, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = file.WriteAt([]byte("x"), location.offset)
+	file.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = ls.readBlock(testStoreBlock(1).CurrBlockHash); err == nil {
+		t.Errorf("readBlock returns no error for a damaged block")
+	}
+	if block := ls.GetBlock(testStoreBlock(1).CurrBlockHash); block != nil {
+		t.Errorf("GetBlock returns a damaged block")
+	}
+	if err = ls.SaveSideBlock(testStoreBlock(1, "a")); err == nil {
+		t.Errorf("SaveSideBlock returns no error for a damaged stored block")
+	}
+	if _, err = ls.RemoveLastBlock(); err == nil {
+		t.Errorf("RemoveLastBlock returns no error for a damaged block")
+	}
+}
@@ -1,242 +1,277 @@
 package main
 
 import (
-	"bytes"
-	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"math/big"
 	"net"
+	"sort"
 )
 
 //Blockchain : Define object Blockchain
 type Blockchain struct {
 	UserID string
 	Blocks []*Block
+	// tree : Main chain and side branches in memory, built from Local Database on first use (see Tree)
+	tree *BlockTree
 }
 
-//Set Full Node Information here
-const fullNodeHost = "localhost"
-const fullNodePort = "9999"
+//Set Full Node Information here. It could be changed by Config.
+var fullNodeHost = "localhost"
+var fullNodePort = "9999"
 
-// LoadFromDB :	Load Blockchain from Database - Both Full Node Database and Local Database.
-// 				If Full Node Database is longer then Local Database, add Full Node's Block headers to Local Blockchain.
+// LoadFromDB :	Synchronize Local Database with peers, then load Blockchain from Local Database.
+// 				Only headers after the common ancestor are downloaded from peers, then data of new blocks (see LoadFromPeers).
+// 				If peer Blockchain has more accumulated work then Local Database, reorganize Local Blockchain to peer's Blocks.
+//				Node runs it when ChainState is created only. Later synchronization is done by ChainState.Sync.
 func (bc *Blockchain) LoadFromDB(userID string) {
 
-	// Get blockchain from Local Blockchain and Full Node Blockchain
+	// Get blockchain from Local Blockchain and peers
 	bc.UserID = userID
 	bc.LoadFromLocalDB(bc.UserID)
-	var bcFullNode Blockchain
-	bcFullNode.LoadFromFullNode(bc.UserID)
-
-	// If Full Node Blockchain is longer then Local Database, save Full Node's Block headers into Database.
-	targetBCLen := len(bcFullNode.Blocks)
-	initialBCLen := len(bc.Blocks)
-	for initialBCLen < targetBCLen {
-		// Add directly to Local Database without validating the Block.
-		// because it is downloaded from Full Node, should be fine.
-		bc.AddBlockDirect(bcFullNode.Blocks[initialBCLen])
-		initialBCLen = len(bc.Blocks)
-	}
+	var bcPeer Blockchain
+	bcPeer.LoadFromPeers(bc.UserID, bc.Blocks)
+	bc.ConnectPeerChain(bcPeer.Blocks)
 }
 
-// LoadFromLocalDB :	Load Blockchain from Local Database. Return an array of Blocks in memory.
-func (bc *Blockchain) LoadFromLocalDB(userID string) {
-	bc.UserID = userID
-	bc.Blocks = LoadChain(bc.UserID)
-	return
-}
+// ConnectPeerChain :	Reorganize Local Blockchain to the chain downloaded from peers (see LoadFromPeers), if it has more work.
+//						Header only blocks could not be applied to UTXO set. Peer's Blocks are used up to the first block whose data is not downloaded,
+//						blocks after it are kept in a side branch, and they are connected when their data is relayed (see AcceptBlock).
+func (bc *Blockchain) ConnectPeerChain(peerBlocks []*Block) {
 
-// LoadFromFullNode : Load Blockchain from Full Node using TCP. Return an array of Blocks in memory.
-func (bc *Blockchain) LoadFromFullNode(userID string) {
-
-	// For Full Node : Just load from Local Database.
-	// If Local Database is empty, create the genesisBlock in memory. Save the block to Database in LoadFromDB())
-	if userID == fullNodePort {
-		bc.LoadFromLocalDB(userID)
-		if len(bc.Blocks) == 0 {
-			genesisHash, _ := hex.DecodeString("0000000000000000000000000000000000000000000000000000000000000000")
-			genesisBlock := CreateBlock(arrayConvertorStringToBytes([]string{"New", "Genesis", "Block"}), genesisHash)
-			bc.Blocks = []*Block{genesisBlock}
-		}
-		return
+	full := 0
+	for full < len(peerBlocks) && len(peerBlocks[full].Data) > 0 {
+		full++
 	}
 
-	// For Normal Node : Establish TCP Connection with Full Node. Download block headers.
-	fullNodeAddr, err := net.ResolveTCPAddr("tcp", fullNodeHost+":"+fullNodePort)
-	if err != nil {
-		fmt.Println("Chain:	Cannot connect to Full Node. Fail to load Blockchain.")
-		fmt.Println(err)
+	// Peer's Blocks are applied to a copy of UTXO set first. Local Database is not changed if any of them is invalid (see Reorganize).
+	if CalChainWork(peerBlocks[:full]).Cmp(CalChainWork(bc.Blocks)) > 0 && !bc.Reorganize(peerBlocks[:full]) {
+		logln(logError, "Chain:	Blocks of peers are invalid. Keep Local Database.")
 		return
 	}
-	fullNodeConn, err := net.DialTCP("tcp", nil, fullNodeAddr)
-	if err != nil {
-		fmt.Println("Chain:	Cannot connect to Full Node. Fail to load Blockchain.")
-		fmt.Println(err)
-		return
+
+	if full > 0 && full < len(peerBlocks) && FindLocator(bc.Blocks, [][]byte{peerBlocks[full-1].CurrBlockHash}) >= 0 {
+		for i := full; i < len(peerBlocks); i++ {
+			SaveSideBlock(peerBlocks[i], bc.UserID)
+			bc.keepInTree(peerBlocks[i])
+		}
+		logf(logInfo, "Chain:	%d header only block(s) are kept in a side branch.\n", len(peerBlocks)-full)
 	}
-	fmt.Printf("Chain:	Connected to Full Node for Synchronization of Blockchain : %s\n", fullNodeAddr.String())
+}
 
-	// Full Node is connected, now
-	// 1. Send "getBC"
-	// 2. Full Node return Block headers, i.e. Blockchain with header only. Deserialize it.
+// CheckUnknownParent :	A block whose parent is unknown could not be checked by CheckBlockContext, and it makes the node synchronize with peers.
+//						Its Bits must not be easier than the expected Bits after the tip by more than one retarget (a factor of 4),
+//						so that such blocks are not cheap to make. Return the BlockError if it is too easy.
+func (bc *Blockchain) CheckUnknownParent(newBlock *Block) error {
 
-	message := []byte("getBC")
-	_, err = fullNodeConn.Write(message)
-	buf := make([]byte, 8192)
-	_, err = fullNodeConn.Read(buf)
-	fullNodeConn.Close()
-	// End of Step 1. buf should be a serialized blockchain []*Block in JSON.
+	limit := CalTarget(CalBitsAt(bc.Blocks, len(bc.Blocks)))
+	limit.Mul(limit, big.NewInt(4))
+	if CalTarget(newBlock.Bits).Cmp(limit) > 0 {
+		return rejectBlock(RejectBadBits, "Bits %08x of a block whose parent is unknown is easier than expected", newBlock.Bits)
+	}
+	return nil
+}
 
-	err = json.Unmarshal(bytes.TrimRight(buf, "\x00"), &bc)
-	// End of Step 2. Deserialize done.
-	return
+// syncInterval : Interval (in second) of synchronization with peers in background.
+//				  New blocks usually arrive earlier by announcements, this is for announcements missed, e.g. when the node is offline.
+const syncInterval = 60
 
+// LoadFromLocalDB :	Load Blockchain from Local Database. Return an array of Blocks in memory.
+func (bc *Blockchain) LoadFromLocalDB(userID string) {
+	bc.UserID = userID
+	bc.Blocks = LoadChain(bc.UserID)
+	bc.tree = nil
+	return
 }
 
 // AddBlock :	Add a new generated block into blockchain - array of block & save in database (Both Local & Full Node)
 // 				First will try to add the block to Full Node Database . Then add the block to Local Database.
-func (bc *Blockchain) AddBlock(newBlock *Block) bool {
-
-	bc.LoadFromDB(bc.UserID)
+//				Block which is not on top of the tip is kept in a side branch. Return nil if the block is stored, otherwise the BlockError.
+func (bc *Blockchain) AddBlock(newBlock *Block) error {
 
 	// First add block to Full Node Blockchain (Skip if this step is Full Node)
-	if bc.AddBlockFullNode(newBlock) == false {
-		return false
+	if err := bc.AddBlockFullNode(newBlock); err != nil {
+		return err
+	}
+
+	// Then add the block to Local Database.
+	return bc.AddBlockLocal(newBlock)
+}
+
+// AcceptBlock :	Add a block relayed by a peer to Local Database. It is not sent to Full Node, because peers relay it.
+//					If the block is stored as header only, e.g. downloaded by LoadFromDB, its data is stored,
+//					then it is applied to UTXO set with the blocks after it if their branch has more work (see ConnectData).
+//					Return nil if the block is new to this node, i.e. it should be relayed to other peers.
+func (bc *Blockchain) AcceptBlock(newBlock *Block) error {
+
+	if knownBlock := LoadBlock(newBlock.CurrBlockHash, bc.UserID); knownBlock != nil {
+		if len(knownBlock.Data) == 0 && len(newBlock.Data) > 0 {
+			SaveSideBlock(newBlock, bc.UserID)
+			bc.keepInTree(newBlock)
+			logln(logInfo, "Chain:	Success in adding data of a known Block to Local Database.")
+			bc.ConnectData(newBlock.CurrBlockHash)
+			return nil
+		}
+		logln(logInfo, "Chain:	Block is already known.")
+		return rejectBlock(RejectDuplicate, "block %x is already known", newBlock.CurrBlockHash)
 	}
+	return bc.AddBlockLocal(newBlock)
+}
+
+// AddBlockLocal :	Add a block to Local Database. Verify it against the tip (see CheckBlockContext) before adding.
+//					Block which is not on top of the tip is kept in a side branch. Return nil if the block is stored, otherwise the BlockError.
+func (bc *Blockchain) AddBlockLocal(newBlock *Block) error {
 
-	// Then add the block to Local Database. Verify its hash before adding.
 	preBlock := bc.Blocks[len(bc.Blocks)-1]
 	if string(newBlock.PrevBlockHash) == string(preBlock.CurrBlockHash) {
-		bc.Blocks = append(bc.Blocks, newBlock)
-		SaveBlock(newBlock, bc.UserID)
-		fmt.Println("Chain:	Success in adding Block to Local Database.")
-		return true
+		if err := CheckBlockContext(newBlock, bc.Blocks, len(bc.Blocks)); err != nil {
+			logln(logError, "Chain:	Failed to add block.", err)
+			return err
+		}
+		if err := bc.ConnectBlock(newBlock); err != nil {
+			return err
+		}
+		logln(logInfo, "Chain:	Success in adding Block to Local Database.")
+		return nil
 	}
 
-	fmt.Println("Chain:	Failed to add block. Invalid Hash.")
-	return false
+	// PrevBlockHash is not the tip. Keep it in a side branch, it may become main chain if it has more work.
+	return bc.AddBlockSide(newBlock)
 
 }
 
-// AddBlockDirect :	Add a block to Local Database directly, not verify it using by sending to Full Node.
-//					Use this function when synchronize with Full Node only.
-func (bc *Blockchain) AddBlockDirect(newBlock *Block) {
 
-	// Allows to add Genesis Block if Blockchain Length == 0
-	if len(bc.Blocks) == 0 {
-		bc.Blocks = append(bc.Blocks, newBlock)
-		SaveBlock(newBlock, bc.UserID)
-		return
-	}
+// ConnectBlock :	Apply the block to UTXO set, then append it to the main chain in Local Database with its undo data.
+//					Transactions packed in the block are removed from Mempool.
+//					Return the BlockError if the block double spends, i.e. it could not be applied to UTXO set,
+//					or the error of Local Database. UTXO set is rolled back if the block could not be saved.
+func (bc *Blockchain) ConnectBlock(newBlock *Block) error {
 
-	// Check the hash before adding block.
-	preBlock := bc.Blocks[len(bc.Blocks)-1]
-	if string(newBlock.PrevBlockHash) == string(preBlock.CurrBlockHash) {
-		bc.Blocks = append(bc.Blocks, newBlock)
-		SaveBlock(newBlock, bc.UserID)
-		return
+	utxo := GetUTXOSet(bc.UserID)
+	undo, err := utxo.ApplyBlock(newBlock, len(bc.Blocks))
+	if err != nil {
+		logln(logError, "Chain:	Failed to add block.", err)
+		return err
 	}
-	fmt.Println("Chain:	Failed to add block when synchronize with full node.")
-	return
-
+	if err = SaveBlock(newBlock, undo, bc.UserID); err != nil {
+		utxo.UndoBlock(undo)
+		return err
+	}
+	bc.Blocks = append(bc.Blocks, newBlock.WithHeight(len(bc.Blocks)))
+	bc.keepInTree(newBlock)
+	GetMempool(bc.UserID).RemoveBlock(newBlock)
+	return nil
 }
 
 // AddBlockFullNode : Add a block to Full Node by establish a TCP connection
-//					  Return true (success) or false (fail)
-func (bc *Blockchain) AddBlockFullNode(newBlock *Block) bool {
+//					  Return nil (success) or the error returned by Full Node (fail).
+//					  Return nil if Full Node is not reachable, i.e. the network works without it,
+//					  or if Full Node knows the block already, e.g. it is relayed by peers earlier.
+func (bc *Blockchain) AddBlockFullNode(newBlock *Block) error {
 
-	// Always return true if the node is Full Node. Because Full node doesn't need to verify block with nearby node.
+	// Always return nil if the node is Full Node. Because Full node doesn't need to verify block with nearby node.
 	if bc.UserID == fullNodePort {
-		return true
+		return nil
 	}
 	// Else Send Block to Full Node
 	fullNodeAddr, err := net.ResolveTCPAddr("tcp", fullNodeHost+":"+fullNodePort)
 	if err != nil {
-		fmt.Println("Chain:	Cannot connect to Full Node. Fail to add block.")
-		fmt.Println(err)
-		return false
+		logln(logError, "Chain:	Cannot connect to Full Node. Fail to add block.")
+		logln(logError, err)
+		return err
 	}
-	fullNodeConn, err := net.DialTCP("tcp", nil, fullNodeAddr)
+	fullNodeConn, err := net.DialTimeout("tcp", fullNodeAddr.String(), peerDialTimeout)
 	if err != nil {
-		fmt.Println("Chain:	Cannot connect to Full Node. Fail to add block.")
-		fmt.Println(err)
-		return false
+		// Full Node is gone. Add the block to Local Database only, peers will receive it by relaying.
+		logln(logError, "Chain:	Cannot connect to Full Node. Block is relayed to peers only.")
+		return nil
 	}
-	fmt.Printf("Chain:	Connected to Full Node for Adding Block: %s\n", fullNodeAddr.String())
+	logf(logDebug, "Chain:	Connected to Full Node for Adding Block: %s\n", fullNodeAddr.String())
 
 	// Full Node is connected, now
-	// 1. Send "addBK". By Default Full Node return PrevBlockHash if connection is success. Ignore this message.
-	// 2. Send the new block to Full Node.
-	// 3. Full Node return either "Success..." or "Fail...". Node can determine whether broadcasting is successfully added to Full Node.
-
-	// Step 1. Send "addBK". Ignore returned message.
-	message := []byte("addBK")
-	_, err = fullNodeConn.Write(message)
-	buf := make([]byte, 8192)
-	_, err = fullNodeConn.Read(buf)
+	// 1. Send "addBK" with the new block to Full Node.
+	// 2. Full Node return a BlockResult. Node can determine whether broadcasting is successfully added to Full Node.
 
-	// Step 2. Send the new block to Full Node.
+	// Step 1. Send the new block to Full Node.
 	newBlockJSON, _ := json.Marshal(newBlock)
-	message = bytes.Join([][]byte{[]byte("addBK"), newBlockJSON}, []byte{})
-	_, err = fullNodeConn.Write(message)
+	err = writeMsg(fullNodeConn, "addBK", newBlockJSON)
 
-	// Step 3. Receive Result from Full Node.
-	buf = make([]byte, 8192)
-	_, err = fullNodeConn.Read(buf)
+	// Step 2. Receive Result from Full Node.
+	var buf []byte
+	if err == nil {
+		_, buf, err = readMsg(fullNodeConn)
+	}
 	fullNodeConn.Close()
-	result := string(bytes.TrimRight(buf, "\x00"))[0:7]
-
-	if result == "Success" {
-		fmt.Println("Chain:	Result - ", result, "in adding Block to Full Node")
-		return true
-	} else if result == "Fail  " {
-		fmt.Println("Chain:	Result - ", result, "in adding Block to Full Node")
-		return false
+	var result BlockResult
+	if err == nil {
+		err = json.Unmarshal(buf, &result)
+	}
+	if err != nil {
+		logln(logError, "Chain:	Cannot read result from Full Node. Fail to add block.")
+		logln(logError, err)
+		return err
 	}
 
-	return false
+	logln(logInfo, "Chain:	Result - ", result.Status, "in adding Block to Full Node")
+	if result.Stored() || result.Status == BlockStatus(RejectDuplicate) {
+		return nil
+	}
+	return result.Err()
 
 }
 
 // PrintChain :	Print all blocks in blockchain
 func (bc *Blockchain) PrintChain() {
 	for i := 0; i < len(bc.Blocks); i++ {
-		fmt.Printf("Chain:	Block #%d\n", i)
+		fmt.Printf("Chain:	Block #%d\n", bc.Blocks[i].Height)
 		fmt.Printf("	> Timestamp	: %010d\n", bc.Blocks[i].Timestamp)
 		fmt.Printf("	> PrevBlockHash	: %x\n", bc.Blocks[i].PrevBlockHash)
 		fmt.Printf("	> Root		: %x\n", bc.Blocks[i].Root)
+		fmt.Printf("	> Bits		: %08x\n", bc.Blocks[i].Bits)
 		fmt.Printf("	> Nonce		: %010d\n", bc.Blocks[i].Nonce)
 		fmt.Printf("	> CurrBlockHash	: %x\n", bc.Blocks[i].CurrBlockHash)
 		fmt.Printf("	> Data		: %s\n", bc.Blocks[i].Data)
-		fmt.Printf("      	Block #%d Header in Byte Stream (80 bytes, equals to 160 digits in hex)\n", i)
-		fmt.Printf("	[Magic#        ][TS    ][PrevBlockHash                                                 ][Root                                                          ][Nonce ]\n")
+		fmt.Printf("	> ExtraNonce	: %010d\n", bc.Blocks[i].ExtraNonce)
+		fmt.Printf("      	Block #%d Header in Byte Stream (80 bytes, equals to 160 digits in hex)\n", bc.Blocks[i].Height)
+		fmt.Printf("	[Magic#][TS    ][PrevBlockHash                                                 ][Root                                                          ][Bits  ][Nonce ]\n")
 		fmt.Printf("	%x\n\n", bc.Blocks[i].ByteStream)
 	}
 }
 
-// ValidateChain :	Check if the whole chain is valid. i.e. all CurrBlockHash & PrevBlockHash match.
+// NextBits :	Return the Bits that the next block on top of this chain must use
+func (bc *Blockchain) NextBits() uint32 {
+	return CalBitsAt(bc.Blocks, len(bc.Blocks))
+}
+
+// MedianTimePast :	Return the median Timestamp of the last 11 blocks. Timestamp of the next block must be later than it.
+func (bc *Blockchain) MedianTimePast() uint32 {
+	return CalMedianTimePast(bc.Blocks, len(bc.Blocks))
+}
+
+// CalMedianTimePast :	Return the median Timestamp of the 11 blocks before blocks[height]
+func CalMedianTimePast(blocks []*Block, height int) uint32 {
+	var timestamps []uint32
+	for i := height - 1; i >= 0 && i >= height-11; i-- {
+		timestamps = append(timestamps, blocks[i].Timestamp)
+	}
+	if len(timestamps) == 0 {
+		return 0
+	}
+	sort.Slice(timestamps, func(i, j int) bool { return timestamps[i] < timestamps[j] })
+	return timestamps[len(timestamps)/2]
+}
+
+// ValidateChain :	Check if the whole chain is valid. i.e. all CurrBlockHash & PrevBlockHash & Bits & Timestamp match.
 func (bc *Blockchain) ValidateChain() bool {
 
-	validFlag := true
 	if len(bc.Blocks) == 0 {
-		validFlag = false
-	} else {
-
-		// Check Genesis Block first:	Only Check CurrBlockHash is Valid
-		if bc.Blocks[0].ValidateBlock() == false {
-			validFlag = false
-		}
-		// Then check other Blocks :	Check CurrBlockHash is Valid & PrevBlockHash Matches
-		if len(bc.Blocks) > 1 {
-			for i := 1; i < len(bc.Blocks); i++ {
-				if bc.Blocks[i].ValidateBlock() == false {
-					validFlag = false
-				}
-				if string(bc.Blocks[i-1].CurrBlockHash) != string(bc.Blocks[i].PrevBlockHash) {
-					validFlag = false
-				}
-			}
+		return false
+	}
+	// Check every Block from Genesis Block :	Check CurrBlockHash is Valid, then PrevBlockHash & Bits & Timestamp against the blocks before it
+	for i := 0; i < len(bc.Blocks); i++ {
+		if bc.Blocks[i].ValidateBlock() == false || CheckBlockContext(bc.Blocks[i], bc.Blocks, i) != nil {
+			return false
 		}
 	}
-	return validFlag
+	return true
 }
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"math/big"
+)
+
+// BlockTree : All known blocks, i.e. main chain and side branches, indexed by CurrBlockHash.
+//
+//	Main chain is the branch with the most accumulated Proof of Work, not the longest one.
+type BlockTree struct {
+	Nodes map[string]*TreeNode
+}
+
+// TreeNode : Node of a BlockTree
+type TreeNode struct {
+	Block     *Block
+	Parent    *TreeNode
+	Height    int
+	ChainWork *big.Int
+}
+
+// Tree : Return the BlockTree of the Blockchain. It is built once (see BuildTree), then kept in memory and updated
+//		  when blocks are stored (see keepInTree), so that adding a block does not read side branches from disk.
+//		  Only the writer goroutine of ChainState uses it.
+func (bc *Blockchain) Tree() *BlockTree {
+	if bc.tree == nil {
+		bc.tree = bc.BuildTree()
+	}
+	return bc.tree
+}
+
+// keepInTree : Add a block stored in Local Database to the BlockTree, if the tree is built.
+//				Otherwise the block is read from Local Database when the tree is built.
+func (bc *Blockchain) keepInTree(block *Block) {
+	if bc.tree != nil {
+		bc.tree.Add(block)
+	}
+}
+
+// BuildTree : Build a BlockTree using main chain in memory and side branches in Local Database
+func (bc *Blockchain) BuildTree() *BlockTree {
+
+	bt := &BlockTree{Nodes: make(map[string]*TreeNode)}
+
+	// Step 1 : Main chain. Genesis Block is the root of the tree.
+	for i := 0; i < len(bc.Blocks); i++ {
+		if i == 0 {
+			bt.Nodes[string(bc.Blocks[i].CurrBlockHash)] = &TreeNode{
+				Block:     bc.Blocks[i],
+				Height:    0,
+				ChainWork: CalWork(bc.Blocks[i].Bits),
+			}
+		} else {
+			bt.Insert(bc.Blocks[i])
+		}
+	}
+
+	// Step 2 : Side branches. A side block may be loaded before its parent, so repeat until nothing can be inserted.
+	pending := LoadSideBlocks(bc.UserID)
+	for len(pending) > 0 {
+		var remain []*Block
+		for i := 0; i < len(pending); i++ {
+			if bt.Insert(pending[i]) == nil {
+				remain = append(remain, pending[i])
+			}
+		}
+		if len(remain) == len(pending) {
+			break
+		}
+		pending = remain
+	}
+
+	return bt
+}
+
+// Insert : Insert a block under its parent. Return nil if its parent is unknown or it is already in the tree.
+func (bt *BlockTree) Insert(block *Block) *TreeNode {
+
+	if bt.Nodes[string(block.CurrBlockHash)] != nil {
+		return nil
+	}
+	parent := bt.Nodes[string(block.PrevBlockHash)]
+	if parent == nil {
+		return nil
+	}
+
+	node := &TreeNode{
+		Block:     block,
+		Parent:    parent,
+		Height:    parent.Height + 1,
+		ChainWork: new(big.Int).Add(parent.ChainWork, CalWork(block.Bits)),
+	}
+	bt.Nodes[string(block.CurrBlockHash)] = node
+	return node
+}
+
+// Add : Insert a block under its parent. If the block is in the tree as header only, its data is kept.
+func (bt *BlockTree) Add(block *Block) {
+	if node := bt.Nodes[string(block.CurrBlockHash)]; node != nil {
+		if len(node.Block.Data) == 0 && len(block.Data) > 0 {
+			node.Block = block
+		}
+		return
+	}
+	bt.Insert(block)
+}
+
+// Path : Return the blocks from Genesis Block to this node
+func (node *TreeNode) Path() []*Block {
+	path := make([]*Block, node.Height+1)
+	for n := node; n != nil; n = n.Parent {
+		path[n.Height] = n.Block
+	}
+	return path
+}
+
+// AddBlockSide :	Add a block whose PrevBlockHash is not the tip of the main chain.
+//					The block is kept in a side branch. Reorganize the main chain if the side branch has more work.
+//					Return nil if the block is stored, otherwise the BlockError.
+func (bc *Blockchain) AddBlockSide(newBlock *Block) error {
+
+	bt := bc.Tree()
+	if bt.Nodes[string(newBlock.CurrBlockHash)] != nil {
+		logln(logError, "Chain:	Failed to add block. Block is already known.")
+		return rejectBlock(RejectDuplicate, "block %x is already known", newBlock.CurrBlockHash)
+	}
+	parent := bt.Nodes[string(newBlock.PrevBlockHash)]
+	if parent == nil {
+		logln(logError, "Chain:	Failed to add block. PrevBlockHash is unknown.")
+		return rejectBlock(RejectBadPrev, "PrevBlockHash %x is unknown", newBlock.PrevBlockHash)
+	}
+	if err := CheckBlockContext(newBlock, parent.Path(), parent.Height+1); err != nil {
+		logln(logError, "Chain:	Failed to add block.", err)
+		return err
+	}
+
+	// Keep the block in a side branch first
+	node := bt.Insert(newBlock)
+	SaveSideBlock(newBlock, bc.UserID)
+	logln(logInfo, "Chain:	Success in adding Block to a side branch.")
+
+	// Tip is selected by accumulated Proof of Work. If work is equal, keep the first seen tip.
+	if node.ChainWork.Cmp(CalChainWork(bc.Blocks)) > 0 {
+		bc.Reorganize(node.Path())
+	}
+	return nil
+}
+
+// ConnectData :	Data of a header only side block is stored. The block, and the blocks after it whose data is stored, could be applied to UTXO set now.
+//					Reorganize the main chain to the branch with the most work among them, if it has more work than the main chain.
+//					Blocks are applied in height order (see Reorganize). Return true if the main chain is replaced.
+func (bc *Blockchain) ConnectData(blockHash []byte) bool {
+
+	bt := bc.Tree()
+	node := bt.Nodes[string(blockHash)]
+	if node == nil {
+		return false
+	}
+
+	// Step 1 : Every block from Genesis Block to the block must have data
+	for n := node; n.Parent != nil; n = n.Parent {
+		if len(n.Block.Data) == 0 {
+			return false
+		}
+	}
+
+	// Step 2 : Find the block with the most work after it, with data from the block to itself
+	var best *TreeNode
+	bestWork := CalChainWork(bc.Blocks)
+	for _, n := range bt.Nodes {
+		if n.ChainWork.Cmp(bestWork) <= 0 || !n.hasDataAfter(node) {
+			continue
+		}
+		best = n
+		bestWork = n.ChainWork
+	}
+	if best == nil {
+		return false
+	}
+	return bc.Reorganize(best.Path())
+}
+
+// hasDataAfter : Check if the node is ancestor itself or after it, and every block between them has data
+func (node *TreeNode) hasDataAfter(ancestor *TreeNode) bool {
+	for n := node; n.Height >= ancestor.Height; n = n.Parent {
+		if n == ancestor {
+			return true
+		}
+		if len(n.Block.Data) == 0 {
+			return false
+		}
+	}
+	return false
+}
+
+// Reorganize :	Replace the main chain by newChain.
+//				1. Check the reorganization using a copy of UTXO set. Cancel it if newChain double spends,
+//				   or undo data of a rolled back block is missing.
+//				2. Roll back blocks after the fork point from Local Database, they become side blocks.
+//				3. Re-apply blocks of newChain after the fork point to Local Database. They are no longer side blocks.
+//				   Transactions of rolled back blocks are returned to Mempool.
+//				Return true if the main chain is replaced. Return false if it stops at a block which could not be saved in Local Database.
+func (bc *Blockchain) Reorganize(newChain []*Block) bool {
+
+	// Find the fork point, i.e. the first height where two chains are different
+	fork := 0
+	for fork < len(bc.Blocks) && fork < len(newChain) && string(bc.Blocks[fork].CurrBlockHash) == string(newChain[fork].CurrBlockHash) {
+		fork++
+	}
+
+	// Step 1 : Roll back and re-apply using a copy of UTXO set
+	utxo := GetUTXOSet(bc.UserID)
+	tmpUTXO := utxo.Clone()
+	for i := len(bc.Blocks) - 1; i >= fork; i-- {
+		undo := LoadUndo(bc.Blocks[i].CurrBlockHash, bc.UserID)
+		if undo == nil {
+			logf(logError, "Chain:	Reorganization is cancelled. Undo data of block %x is missing.\n", bc.Blocks[i].CurrBlockHash)
+			return false
+		}
+		tmpUTXO.UndoBlock(undo)
+	}
+	var undos []*UndoData
+	for i := fork; i < len(newChain); i++ {
+		undo, err := tmpUTXO.ApplyBlock(newChain[i], i)
+		if err != nil {
+			logln(logError, "Chain:	Reorganization is cancelled.", err)
+			return false
+		}
+		undos = append(undos, undo)
+	}
+	logf(logInfo, "Chain:	Reorganization at height %d, %d block(s) rolled back, %d block(s) applied.\n", fork, len(bc.Blocks)-fork, len(newChain)-fork)
+
+	// Step 2 : Roll back
+	var oldBlocks []*Block
+	for len(bc.Blocks) > fork {
+		oldBlocks = append(oldBlocks, RemoveLastBlock(bc.UserID))
+		bc.Blocks = bc.Blocks[:len(bc.Blocks)-1]
+	}
+
+	// Step 3 : Re-apply. If Local Database fails, stop at the last saved block, and roll back the rest in the copy of UTXO set.
+	mp := GetMempool(bc.UserID)
+	replaced := true
+	for i := fork; i < len(newChain); i++ {
+		if SaveBlock(newChain[i], undos[i-fork], bc.UserID) != nil {
+			logf(logError, "Chain:	Reorganization stops at height %d.\n", i)
+			for j := len(newChain) - 1; j >= i; j-- {
+				tmpUTXO.UndoBlock(undos[j-fork])
+			}
+			replaced = false
+			break
+		}
+		bc.Blocks = append(bc.Blocks, newChain[i].WithHeight(i))
+		bc.keepInTree(newChain[i])
+		mp.RemoveBlock(newChain[i])
+	}
+	utxo.Replace(tmpUTXO)
+
+	// Transactions of rolled back blocks are pending again, unless they are spent in newChain
+	for i := 0; i < len(oldBlocks); i++ {
+		if oldBlocks[i] == nil {
+			continue
+		}
+		for j := 1; j < len(oldBlocks[i].Data); j++ {
+			mp.Add(oldBlocks[i].Data[j], utxo)
+		}
+	}
+	return replaced
+}
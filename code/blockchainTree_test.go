@@ -0,0 +1,37 @@
+package main
+
+import (
+	"math/big"
+	"testing"
+)
+
+func TestBlockTreeAdd(t *testing.T) {
+
+	genesis := testStoreBlock(1)
+	genesis.Bits = initialBits
+	bt := &BlockTree{Nodes: map[string]*TreeNode{string(genesis.CurrBlockHash): {Block: genesis, ChainWork: big.NewInt(1)}}}
+
+	// A header only block is kept, then replaced by the same block with data
+	block := testStoreBlock(2, "a")
+	block.PrevBlockHash = genesis.CurrBlockHash
+	block.Bits = initialBits
+	bt.Add(block.CopyHeader())
+	node := bt.Nodes[string(block.CurrBlockHash)]
+	if node == nil || node.Height != 1 || len(node.Block.Data) != 0 {
+		t.Fatalf("header only block is not inserted at height 1")
+	}
+	bt.Add(block)
+	if bt.Nodes[string(block.CurrBlockHash)] != node || len(node.Block.Data) != 1 {
+		t.Errorf("data of a header only block is not kept")
+	}
+
+	// Data is never replaced by a header only block, and a block whose parent is unknown is not inserted
+	bt.Add(block.CopyHeader())
+	if len(node.Block.Data) != 1 {
+		t.Errorf("data is replaced by a header only block")
+	}
+	bt.Add(testStoreBlock(3))
+	if len(bt.Nodes) != 2 {
+		t.Errorf("tree has %d nodes, expected 2", len(bt.Nodes))
+	}
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"math/bits"
+	"strconv"
+	"sync"
+)
+
+// UTXOSet : Set of unspent transaction outputs of the main chain, indexed by "<PrevTxHash in hex>:<OutIndex>"
+//
+//	Every block of main chain is applied with its data. Header only block could not be applied, it is kept in a side branch until its data arrives.
+type UTXOSet struct {
+	mutex   sync.Mutex
+	Outputs map[string]*UTXOEntry
+}
+
+// UTXOEntry : An unspent output
+type UTXOEntry struct {
+	TxHash []byte
+	Index  int
+	Output *TxOutput
+}
+
+// UndoData : Changes made by a block to UTXOSet, so that the block could be rolled back.
+type UndoData struct {
+	// Outputs spent by the block, restored when rolling back
+	Spent []*UTXOEntry
+	// Outputs created by the block, removed when rolling back
+	Created []*UTXOEntry
+}
+
+// utxoKey : Key of an output in UTXOSet
+func utxoKey(txHash []byte, index int) string {
+	return hex.EncodeToString(txHash) + ":" + strconv.Itoa(index)
+}
+
+// utxoSets : UTXOSet is built once per userID, and shared by all connections of this process
+var utxoSets = make(map[string]*UTXOSet)
+var utxoSetsMutex sync.Mutex
+
+// GetUTXOSet : Return the UTXOSet of userID. Build it by replaying the main chain if it is not built yet.
+func GetUTXOSet(userID string) *UTXOSet {
+
+	utxoSetsMutex.Lock()
+	defer utxoSetsMutex.Unlock()
+
+	if us, ok := utxoSets[userID]; ok {
+		return us
+	}
+	us := &UTXOSet{Outputs: make(map[string]*UTXOEntry)}
+	chain := LoadChain(userID)
+	for i := 0; i < len(chain); i++ {
+		if _, err := us.ApplyBlock(chain[i], i); err != nil {
+			logf(logError, "Chain:	Block #%d is inconsistent with UTXO set, %s\n", i, err)
+		}
+	}
+	utxoSets[userID] = us
+	return us
+}
+
+// Clone : Copy the UTXOSet, so that changes could be tested before applying to the shared set
+func (us *UTXOSet) Clone() *UTXOSet {
+	us.mutex.Lock()
+	defer us.mutex.Unlock()
+
+	clone := &UTXOSet{Outputs: make(map[string]*UTXOEntry, len(us.Outputs))}
+	for key, entry := range us.Outputs {
+		clone.Outputs[key] = entry
+	}
+	return clone
+}
+
+// Replace : Replace the content of UTXOSet by another set
+func (us *UTXOSet) Replace(other *UTXOSet) {
+	us.mutex.Lock()
+	defer us.mutex.Unlock()
+
+	us.Outputs = other.Outputs
+}
+
+
+// CheckTransaction : Check if a Transaction could be applied on top of the main chain. Return its fee.
+//					  Inputs must be unspent outputs owned by the sender, and outputs are not more than inputs.
+func (us *UTXOSet) CheckTransaction(tx *Transaction) (uint64, error) {
+	us.mutex.Lock()
+	defer us.mutex.Unlock()
+
+	var inputSum, outputSum uint64
+	var ok bool
+	spentFlag := make(map[string]bool)
+	for j := 0; j < len(tx.Inputs); j++ {
+		key := utxoKey(tx.Inputs[j].PrevTxHash, tx.Inputs[j].OutIndex)
+		entry := us.Outputs[key]
+		if spentFlag[key] {
+			return 0, fmt.Errorf("transaction double spends %s", key)
+		}
+		if entry == nil {
+			return 0, fmt.Errorf("transaction spends unknown or spent output %s", key)
+		}
+		spentFlag[key] = true
+		if string(entry.Output.Recipient) != string(tx.SenderAddress()) {
+			return 0, fmt.Errorf("transaction spends output %s of others", key)
+		}
+		if inputSum, ok = addAmount(inputSum, entry.Output.Amount); !ok {
+			return 0, fmt.Errorf("transaction inputs overflow")
+		}
+	}
+	for j := 0; j < len(tx.Outputs); j++ {
+		if outputSum, ok = addAmount(outputSum, tx.Outputs[j].Amount); !ok {
+			return 0, fmt.Errorf("transaction outputs overflow")
+		}
+	}
+	if outputSum > inputSum {
+		return 0, fmt.Errorf("transaction spends more than its inputs")
+	}
+	if tx.Fee != 0 && tx.Fee != inputSum-outputSum {
+		return 0, fmt.Errorf("transaction declares fee %d, but pays %d", tx.Fee, inputSum-outputSum)
+	}
+	return inputSum - outputSum, nil
+}
+
+// SpendTransaction : Remove the inputs of a Transaction from UTXOSet. Used to pack transactions without conflict.
+func (us *UTXOSet) SpendTransaction(tx *Transaction) {
+	us.mutex.Lock()
+	defer us.mutex.Unlock()
+
+	for j := 0; j < len(tx.Inputs); j++ {
+		delete(us.Outputs, utxoKey(tx.Inputs[j].PrevTxHash, tx.Inputs[j].OutIndex))
+	}
+}
+
+// ValidateCoinbaseHeight :	Check the coinbase of a new block using the height of its parent.
+//							Only the height is checked here. Reward (<= subsidy + fees) is checked with UTXO set when the block is applied,
+//							i.e. by ConnectBlock if it extends the tip, or when its side branch becomes main chain.
+func (bc *Blockchain) ValidateCoinbaseHeight(newBlock *Block) error {
+
+	parent := bc.Tree().Nodes[string(newBlock.PrevBlockHash)]
+	if parent == nil {
+		return rejectBlock(RejectBadPrev, "PrevBlockHash %x is unknown", newBlock.PrevBlockHash)
+	}
+
+	coinbase, err := newBlock.ParseCoinbase()
+	if err != nil {
+		return rejectBlock(RejectBadCoinbase, "%s", err)
+	}
+	if coinbase.Nonce>>32 != uint64(parent.Height+1) {
+		return rejectBlock(RejectBadCoinbase, "coinbase height is %d, expected %d", coinbase.Nonce>>32, parent.Height+1)
+	}
+	return nil
+}
+
+// ApplyBlock : Spend the inputs and create the outputs of every Transaction in the block at height.
+//
+//	Return error if an input is spent already (double spend), or owned by others, or outputs are more than inputs,
+//	or the declared fee is wrong, or the coinbase pays more than subsidy + fees. UTXOSet is not changed if error is returned.
+//	The error is a BlockError, i.e. bad-tx or bad-coinbase.
+func (us *UTXOSet) ApplyBlock(bk *Block, height int) (*UndoData, error) {
+	us.mutex.Lock()
+	defer us.mutex.Unlock()
+
+	undo := &UndoData{}
+
+	// Genesis Block contains no Transaction. Header only block could not be applied, its outputs are unknown.
+	if isGenesisBlock(bk) {
+		return undo, nil
+	}
+	if len(bk.Data) == 0 {
+		return nil, rejectBlock(RejectMalformed, "block %x is header only", bk.CurrBlockHash)
+	}
+
+	// Step 1 : Parse and check all transactions first. Outputs created earlier in the same block could be spent.
+	spentFlag := make(map[string]bool)
+	createdEntry := make(map[string]*UTXOEntry)
+	var fees uint64
+
+	coinbase, err := bk.ParseCoinbase()
+	if err != nil {
+		return nil, rejectBlock(RejectBadCoinbase, "%s", err)
+	}
+	coinbaseHash := coinbase.Hash()
+	for j := 0; j < len(coinbase.Outputs); j++ {
+		createdEntry[utxoKey(coinbaseHash, j)] = &UTXOEntry{TxHash: coinbaseHash, Index: j, Output: coinbase.Outputs[j]}
+	}
+	for i := 1; i < len(bk.Data); i++ {
+		tx, err := DeserializeTransaction(bk.Data[i])
+		if err != nil {
+			return nil, rejectBlock(RejectBadTx, "data #%d is not a transaction", i)
+		}
+		txHash := tx.Hash()
+
+		var inputSum, outputSum uint64
+		var ok bool
+		for j := 0; j < len(tx.Inputs); j++ {
+			key := utxoKey(tx.Inputs[j].PrevTxHash, tx.Inputs[j].OutIndex)
+			if spentFlag[key] {
+				return nil, rejectBlock(RejectBadTx, "transaction %x double spends %s", txHash, key)
+			}
+			entry := createdEntry[key]
+			if entry == nil {
+				entry = us.Outputs[key]
+			}
+			if entry == nil {
+				return nil, rejectBlock(RejectBadTx, "transaction %x spends unknown or spent output %s", txHash, key)
+			}
+			if string(entry.Output.Recipient) != string(tx.SenderAddress()) {
+				return nil, rejectBlock(RejectBadTx, "transaction %x spends output %s of others", txHash, key)
+			}
+			spentFlag[key] = true
+			if inputSum, ok = addAmount(inputSum, entry.Output.Amount); !ok {
+				return nil, rejectBlock(RejectBadTx, "transaction %x inputs overflow", txHash)
+			}
+		}
+		for j := 0; j < len(tx.Outputs); j++ {
+			if outputSum, ok = addAmount(outputSum, tx.Outputs[j].Amount); !ok {
+				return nil, rejectBlock(RejectBadTx, "transaction %x outputs overflow", txHash)
+			}
+			key := utxoKey(txHash, j)
+			createdEntry[key] = &UTXOEntry{TxHash: txHash, Index: j, Output: tx.Outputs[j]}
+		}
+		if outputSum > inputSum {
+			return nil, rejectBlock(RejectBadTx, "transaction %x spends more than its inputs", txHash)
+		}
+		if tx.Fee != 0 && tx.Fee != inputSum-outputSum {
+			return nil, rejectBlock(RejectBadTx, "transaction %x declares fee %d, but pays %d", txHash, tx.Fee, inputSum-outputSum)
+		}
+		if fees, ok = addAmount(fees, inputSum-outputSum); !ok {
+			return nil, rejectBlock(RejectBadTx, "fees of block overflow")
+		}
+	}
+
+	// Coinbase could not pay more than subsidy + fees
+	if err = bk.ValidateCoinbase(height, fees); err != nil {
+		return nil, rejectBlock(RejectBadCoinbase, "%s", err)
+	}
+
+	// Step 2 : All transactions are valid. Update UTXOSet and record the undo data.
+	for key := range spentFlag {
+		if entry, ok := us.Outputs[key]; ok {
+			undo.Spent = append(undo.Spent, entry)
+			delete(us.Outputs, key)
+		}
+	}
+	for key, entry := range createdEntry {
+		if !spentFlag[key] {
+			undo.Created = append(undo.Created, entry)
+			us.Outputs[key] = entry
+		}
+	}
+	return undo, nil
+}
+
+// UndoBlock : Roll back a block using its undo data
+func (us *UTXOSet) UndoBlock(undo *UndoData) {
+	us.mutex.Lock()
+	defer us.mutex.Unlock()
+
+	for i := 0; i < len(undo.Created); i++ {
+		delete(us.Outputs, utxoKey(undo.Created[i].TxHash, undo.Created[i].Index))
+	}
+	for i := 0; i < len(undo.Spent); i++ {
+		us.Outputs[utxoKey(undo.Spent[i].TxHash, undo.Spent[i].Index)] = undo.Spent[i]
+	}
+}
+
+// FindByAddress : Return all unspent outputs owned by an address
+func (us *UTXOSet) FindByAddress(address []byte) []*UTXOEntry {
+	us.mutex.Lock()
+	defer us.mutex.Unlock()
+
+	entries := []*UTXOEntry{}
+	for _, entry := range us.Outputs {
+		if string(entry.Output.Recipient) == string(address) {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+// addAmount : Return sum + amount. Return false if the sum overflows, i.e. the amounts could not be valid.
+func addAmount(sum uint64, amount uint64) (uint64, bool) {
+	total, carry := bits.Add64(sum, amount, 0)
+	return total, carry == 0
+}
+
+// isGenesisBlock : Genesis Block is the block whose hash is GenesisHash. Any other block with PrevBlockHash all "0" is not Genesis Block.
+func isGenesisBlock(bk *Block) bool {
+	return bytes.Equal(bk.CurrBlockHash, GenesisHash())
+}
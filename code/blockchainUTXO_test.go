@@ -0,0 +1,158 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"math"
+	"strings"
+	"testing"
+)
+
+// testUTXOSet : A UTXOSet with one output of amount owned by a new key. Return the set, the key and the hash of the funding transaction.
+func testUTXOSet(t *testing.T, amount uint64) (*UTXOSet, *ecdsa.PrivateKey, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fundHash := make([]byte, 32)
+	fundHash[0] = 1
+	us := &UTXOSet{Outputs: make(map[string]*UTXOEntry)}
+	us.Outputs[utxoKey(fundHash, 0)] = &UTXOEntry{TxHash: fundHash, Index: 0, Output: &TxOutput{Recipient: CalAddress(&key.PublicKey), Amount: amount}}
+	return us, key, fundHash
+}
+
+// testBlock : A block at height 1 with a coinbase paying reward, then txs
+func testBlock(reward []uint64, txs ...*Transaction) *Block {
+	coinbase := CreateCoinbase(nil, 0, 1, 0)
+	for i := 0; i < len(reward); i++ {
+		coinbase.Outputs = append(coinbase.Outputs, &TxOutput{Recipient: make([]byte, 32), Amount: reward[i]})
+	}
+	prevHash := make([]byte, 32)
+	prevHash[31] = 1
+	bk := &Block{PrevBlockHash: prevHash, Data: [][]byte{coinbase.Serialize()}}
+	for i := 0; i < len(txs); i++ {
+		bk.Data = append(bk.Data, txs[i].Serialize())
+	}
+	return bk
+}
+
+func TestUTXOAmountOverflow(t *testing.T) {
+
+	tests := []struct {
+		name    string
+		outputs []uint64
+		fee     uint64
+		errText string
+	}{
+		{"valid", []uint64{6, 3}, 1, ""},
+		{"more than inputs", []uint64{6, 5}, 0, "more than its inputs"},
+		{"outputs wrap to input", []uint64{math.MaxUint64, 11}, 0, "outputs overflow"},
+		{"outputs wrap to zero", []uint64{math.MaxUint64, 1}, 0, "outputs overflow"},
+	}
+	for _, test := range tests {
+		us, key, fundHash := testUTXOSet(t, 10)
+		var outputs []*TxOutput
+		for i := 0; i < len(test.outputs); i++ {
+			outputs = append(outputs, &TxOutput{Recipient: make([]byte, 32), Amount: test.outputs[i]})
+		}
+		tx, err := CreateTransaction(key, []*TxInput{{PrevTxHash: fundHash, OutIndex: 0}}, outputs, test.fee)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		fee, err := us.CheckTransaction(tx)
+		if !matchError(err, test.errText) {
+			t.Errorf("%s: CheckTransaction returns %v, expected %q", test.name, err, test.errText)
+		}
+		if err == nil && fee != test.fee {
+			t.Errorf("%s: CheckTransaction returns fee %d, expected %d", test.name, fee, test.fee)
+		}
+
+		_, err = us.ApplyBlock(testBlock([]uint64{CalSubsidy(1)}, tx), 1)
+		if !matchError(err, test.errText) {
+			t.Errorf("%s: ApplyBlock returns %v, expected %q", test.name, err, test.errText)
+		}
+		if err != nil && len(us.Outputs) != 1 {
+			t.Errorf("%s: UTXOSet is changed by a rejected block", test.name)
+		}
+	}
+}
+
+func TestUTXOHeaderOnlyBlock(t *testing.T) {
+
+	us, _, _ := testUTXOSet(t, 10)
+	header := testBlock([]uint64{CalSubsidy(1)}).CopyHeader()
+	if _, err := us.ApplyBlock(header, 1); !matchError(err, "header only") {
+		t.Fatalf("ApplyBlock of a header only block returns %v", err)
+	}
+	if len(us.Outputs) != 1 {
+		t.Errorf("UTXOSet is changed by a header only block")
+	}
+}
+
+func TestUTXOApplyUndo(t *testing.T) {
+
+	us, key, fundHash := testUTXOSet(t, 10)
+	fundKey := utxoKey(fundHash, 0)
+
+	// tx1 spends the funding output, tx2 spends an output of tx1 in the same block
+	tx1, err := CreateTransaction(key, []*TxInput{{PrevTxHash: fundHash, OutIndex: 0}}, []*TxOutput{{Recipient: CalAddress(&key.PublicKey), Amount: 6}, {Recipient: make([]byte, 32), Amount: 3}}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx2, err := CreateTransaction(key, []*TxInput{{PrevTxHash: tx1.Hash(), OutIndex: 0}}, []*TxOutput{{Recipient: make([]byte, 32), Amount: 6}}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bk := testBlock([]uint64{CalSubsidy(1) + 1}, tx1, tx2)
+	coinbase, _ := bk.ParseCoinbase()
+
+	undo, err := us.ApplyBlock(bk, 1)
+	if err != nil {
+		t.Fatalf("ApplyBlock returns %v", err)
+	}
+	expected := []string{utxoKey(coinbase.Hash(), 0), utxoKey(tx1.Hash(), 1), utxoKey(tx2.Hash(), 0)}
+	if len(us.Outputs) != len(expected) {
+		t.Errorf("UTXOSet has %d output(s) after ApplyBlock, expected %d", len(us.Outputs), len(expected))
+	}
+	for _, key := range expected {
+		if us.Outputs[key] == nil {
+			t.Errorf("output %s is not in UTXOSet after ApplyBlock", key)
+		}
+	}
+	if us.Outputs[fundKey] != nil || us.Outputs[utxoKey(tx1.Hash(), 0)] != nil {
+		t.Errorf("spent outputs are still in UTXOSet after ApplyBlock")
+	}
+
+	// Undo data is saved in JSON, then the block is rolled back
+	undoJSON, _ := json.Marshal(undo)
+	var saved *UndoData
+	if err = json.Unmarshal(undoJSON, &saved); err != nil {
+		t.Fatal(err)
+	}
+	us.UndoBlock(saved)
+	if len(us.Outputs) != 1 || us.Outputs[fundKey] == nil || us.Outputs[fundKey].Output.Amount != 10 {
+		t.Errorf("UTXOSet after UndoBlock is %v, expected only the funding output", us.Outputs)
+	}
+
+	// The same output could not be spent twice in a block
+	if _, err = us.ApplyBlock(testBlock([]uint64{CalSubsidy(1) + 2}, tx1, tx1), 1); !matchError(err, "double spends") {
+		t.Errorf("ApplyBlock of a double spend returns %v", err)
+	}
+	if _, err = us.ApplyBlock(testBlock([]uint64{CalSubsidy(1)}, tx2), 1); !matchError(err, "unknown or spent") {
+		t.Errorf("ApplyBlock spending an unknown output returns %v", err)
+	}
+	if len(us.Outputs) != 1 {
+		t.Errorf("UTXOSet is changed by rejected blocks")
+	}
+}
+
+// matchError : Check if err contains errText, or err is nil if errText is empty
+func matchError(err error, errText string) bool {
+	if errText == "" {
+		return err == nil
+	}
+	return err != nil && strings.Contains(err.Error(), errText)
+}
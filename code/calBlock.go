@@ -5,6 +5,7 @@ import (
 	"crypto/sha256"
 	"encoding/binary"
 	"encoding/hex"
+	"sync"
 	"time"
 )
 
@@ -14,36 +15,105 @@ type Block struct {
 	Timestamp     uint32
 	PrevBlockHash []byte
 	Root          []byte
+	Bits          uint32
 	Nonce         uint32
-	// Block Data
-	Data [][]byte
+	// Block Data. Data[0] is the coinbase transaction which pays the miner, and commits ExtraNonce into Merkle Tree Root.
+	Data       [][]byte
+	ExtraNonce uint32
 	// Block hash,  can be computed using header
 	CurrBlockHash []byte
+	// Height in main chain, i.e. number of blocks before it. It is not a part of Block Header, so it is not covered by Proof of Work.
+	// Set by Local Database when the block is read (see LogStore.readBlock), -1 if the block is in a side branch.
+	// It is never sent or stored, replies return heights explicitly (see BlockReply).
+	Height int `json:"-"`
 	// Byte Stream : Serialized Block Header
 	//	Block is defines as
-	//	8	bytes:	MagicNumber		(16-digit hexadecimal integer, 00004B61726C4E67)
+	//	4	bytes:	MagicNumber		(8-digit hexadecimal integer, 4B614E67)
 	//	4	bytes:	Timestamp		(10-digit decimal positive integer)
 	//	32	bytes:	PrevBlockHash	(64-digit hexadecimal integer)
 	//	32	bytes:	MerkleTreeRoot	(64-digit hexadecimal integer)
+	//	4	bytes:	Bits			(8-digit hexadecimal integer, compact target of Proof of Work)
 	//	4	bytes:	Nonce			(10-digit decimal positive integer)
 	//	Variable :	Data			(UTF-8)
 	// Length of Header = 80 bytes
 	ByteStream []byte
 }
 
+// maxBlockDataSize : Maximum total size (in byte) of Block.Data. See Config.
+var maxBlockDataSize = 1024 * 1024
+
+// genesisTimestamp : Timestamp of Genesis Block, 2020-01-01 00:00:00 UTC
+const genesisTimestamp = 1577836800
+
+// genesisBits, genesisNonce & genesisHashHex : Proof of Work of Genesis Block with the default initialBits, so that it is not mined at every startup.
+//											   They are checked by TestGenesisBlock, which mines Genesis Block again.
+const genesisBits = 0x1f00ffff
+const genesisNonce = 11910
+const genesisHashHex = "00001de4cb6707878f491dfc14857bd1cdcd2ff8dba107396cdbbb93bdede9f8"
+
+// CreateGenesisBlock : Create Genesis Block. It is the same in every node, so that nodes started separately are on the same chain.
+//						With the default initialBits, Nonce is genesisNonce. Otherwise Nonce is searched by one goroutine from 0,
+//						so the result is always the same.
+func CreateGenesisBlock() *Block {
+
+	block := &Block{
+		Timestamp:     genesisTimestamp,
+		PrevBlockHash: make([]byte, 32),
+		Bits:          initialBits,
+		Data:          append([][]byte{CreateCoinbase(nil, 0, 0, 0).Serialize()}, arrayConvertorStringToBytes([]string{"New", "Genesis", "Block"})...),
+	}
+	block.SetExtraNonce(0)
+	if block.Bits == genesisBits {
+		block.Nonce = genesisNonce
+		block.Serialize()
+		block.CalCurrHash()
+	} else {
+		block.CalNoncePOWParallel(1, nil)
+	}
+
+	return block
+}
+
+// genesisHash : Hash of Genesis Block. It is calculated once, then shared by every chain.
+var (
+	genesisHash     []byte
+	genesisHashOnce sync.Once
+)
+
+// GenesisHash : Return the hash of Genesis Block. It is genesisHashHex with the default initialBits.
+func GenesisHash() []byte {
+	genesisHashOnce.Do(func() {
+		if initialBits == genesisBits {
+			genesisHash, _ = hex.DecodeString(genesisHashHex)
+			return
+		}
+		genesisHash = CreateGenesisBlock().CurrBlockHash
+	})
+	return genesisHash
+}
+
 // CreateBlock : Create new Block
-func CreateBlock(dataInput [][]byte, PrevBlockHash []byte) *Block {
+func CreateBlock(coinbase *Transaction, dataInput [][]byte, PrevBlockHash []byte, bits uint32) *Block {
+
+	block := PrepareBlock(coinbase, dataInput, PrevBlockHash, bits)
+
+	block.CalNoncePOW()
+
+	return block
+}
+
+// PrepareBlock : Create new Block without Proof of Work, i.e. Nonce is not calculated yet
+func PrepareBlock(coinbase *Transaction, dataInput [][]byte, PrevBlockHash []byte, bits uint32) *Block {
 
 	time.Sleep(1 * time.Second)
 
 	block := &Block{
 		Timestamp:     uint32(time.Now().Unix()),
 		PrevBlockHash: PrevBlockHash,
-		Root:          CalRoot(dataInput),
-		Data:          dataInput,
+		Bits:          bits,
+		Data:          append([][]byte{coinbase.Serialize()}, dataInput...),
 	}
-
-	block.CalNoncePOW()
+	block.SetExtraNonce(0)
 
 	return block
 }
@@ -53,12 +123,15 @@ func CreateBlock(dataInput [][]byte, PrevBlockHash []byte) *Block {
 func (bk *Block) Serialize() {
 
 	// Convert everythings in header to []byte
-	byteMagicNumber := make([]byte, 8)
-	byteMagicNumber, _ = hex.DecodeString("00004B61726C4E67")
+	byteMagicNumber := make([]byte, 4)
+	byteMagicNumber, _ = hex.DecodeString("4B614E67")
 
 	byteTimestamp := make([]byte, 4)
 	binary.BigEndian.PutUint32(byteTimestamp, bk.Timestamp)
 
+	byteBits := make([]byte, 4)
+	binary.BigEndian.PutUint32(byteBits, bk.Bits)
+
 	byteNonce := make([]byte, 4)
 	binary.BigEndian.PutUint32(byteNonce, bk.Nonce)
 
@@ -69,6 +142,7 @@ func (bk *Block) Serialize() {
 			byteTimestamp,
 			bk.PrevBlockHash,
 			bk.Root,
+			byteBits,
 			byteNonce,
 		},
 		[]byte{},
@@ -78,65 +152,53 @@ func (bk *Block) Serialize() {
 // CalCurrHash : Calculation CurrBlockHash using SHA256
 func (bk *Block) CalCurrHash() {
 	h := sha256.New()
-	h.Write(bk.ByteStream[8:])
+	h.Write(bk.ByteStream[4:])
 	bk.CurrBlockHash = h.Sum(nil)
 }
 
-// targetPOW : The target number of "0" in Proof of Work.
-//			   The first n-digit of CurrBlockHash (in hexadecimal integer) should be "0".
-const targetPOW = 4
-
-// CalNoncePOW : Start Proof of Work. Change Nonce unit targetPOW is archieved.
-func (bk *Block) CalNoncePOW() {
-	var tryNonce uint32
-	var tryFlag bool
-	tryNonce = 0
-
-	for {
-		// Step 1 : Convert Block Header to byte stearm, and calculation its CurrBlockHash
-		bk.Nonce = tryNonce
-		bk.Serialize()
-		bk.CalCurrHash()
-		tryFlag = true
-		// Step 2 : If the 1st n-digit of CurrBlockHash == "0", miningin is success
-		for i := 0; i < targetPOW; i = i + 1 {
-			if hex.EncodeToString(bk.CurrBlockHash)[i:i+1] != "0" {
-				tryFlag = false
-			}
-		}
-		// Step 3: Exit if mining is success, else try nonce = nonce + 1
-		if tryFlag == false {
-			tryNonce = tryNonce + 1
-		} else {
-			break
-		}
-	}
+// CalNoncePOW : Start Proof of Work. Change Nonce unit CurrBlockHash <= target of Bits.
+//				 Nonce is searched by minerThreads goroutines in parallel.
+//				 Timestamp and ExtraNonce are rolled if no Nonce is valid, so it always returns true.
+func (bk *Block) CalNoncePOW() bool {
+	foundFlag, _ := bk.CalNoncePOWRolling(minerThreads, nil)
+	return foundFlag
+}
 
+// SetExtraNonce : Change ExtraNonce. Update the coinbase transaction and Merkle Tree Root accordingly.
+func (bk *Block) SetExtraNonce(extraNonce uint32) {
+	coinbase, err := bk.ParseCoinbase()
+	if err != nil {
+		return
+	}
+	bk.ExtraNonce = extraNonce
+	coinbase.Nonce = coinbase.Nonce&0xffffffff00000000 | uint64(extraNonce)
+	bk.Data[0] = coinbase.Serialize()
+	bk.Root = CalRoot(bk.Data)
 }
 
-// ValidateBlock : Check if the block is valid.
+// ValidateBlock : Check if the block is valid, i.e. Proof of Work of the header, and Data against the header.
+//				  See CheckHeader & CheckData for the reason if it is not valid.
 func (bk *Block) ValidateBlock() bool {
+	return bk.CheckHeader() == nil && bk.CheckData() == nil
+}
 
-	// Step 1 : Extract basic Header information
-	chkBk := &Block{
+// CopyHeader : Return a copy of the Block which contains Block Header, CurrBlockHash and Height only, i.e. without Data
+func (bk *Block) CopyHeader() *Block {
+	return &Block{
 		Timestamp:     bk.Timestamp,
 		PrevBlockHash: bk.PrevBlockHash,
 		Root:          bk.Root,
+		Bits:          bk.Bits,
 		Nonce:         bk.Nonce,
+		CurrBlockHash: bk.CurrBlockHash,
+		Height:        bk.Height,
 	}
+}
 
-	// Step 2 : Calculation CurrBlockHash
-	chkBk.Serialize()
-	chkBk.CalCurrHash()
-
-	// Step 3 : Check if CurrBlockHash is valid
-	var chkFlag bool
-	chkFlag = true
-	for i := 0; i < targetPOW; i = i + 1 {
-		if hex.EncodeToString(chkBk.CurrBlockHash)[i:i+1] != "0" {
-			chkFlag = false
-		}
-	}
-
-	return chkFlag
+// WithHeight : Return a shallow copy of the Block with Height set.
+//				Blocks of a published Blockchain are read by Snapshot readers, so Height is never changed in place.
+func (bk *Block) WithHeight(height int) *Block {
+	block := *bk
+	block.Height = height
+	return &block
 }
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+func TestGenesisBlock(t *testing.T) {
+
+	oldBits := initialBits
+	initialBits = genesisBits
+	defer func() { initialBits = oldBits }()
+
+	// Mine Genesis Block again from Nonce 0, it must give the hardcoded Nonce and hash
+	block := CreateGenesisBlock()
+	block.Nonce = 0
+	block.CalNoncePOWParallel(1, nil)
+	if block.Nonce != genesisNonce {
+		t.Errorf("Nonce of Genesis Block is %d, genesisNonce is %d", block.Nonce, genesisNonce)
+	}
+	if hex.EncodeToString(block.CurrBlockHash) != genesisHashHex {
+		t.Errorf("hash of Genesis Block is %x, genesisHashHex is %s", block.CurrBlockHash, genesisHashHex)
+	}
+
+	// The hardcoded Genesis Block is valid
+	genesis := CreateGenesisBlock()
+	if !genesis.ValidateBlock() {
+		t.Errorf("Genesis Block with genesisNonce is not valid")
+	}
+	if hex.EncodeToString(GenesisHash()) != genesisHashHex || hex.EncodeToString(genesis.CurrBlockHash) != genesisHashHex {
+		t.Errorf("GenesisHash is %x, Genesis Block is %x, expected %s", GenesisHash(), genesis.CurrBlockHash, genesisHashHex)
+	}
+}
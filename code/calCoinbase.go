@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+)
+
+// blockSubsidy : Reward of a block before the first halving.
+//
+//	Default is 50, could be changed by Config, e.g. BLOCK_SUBSIDY. 0 means blocks pay fees only.
+var blockSubsidy uint64 = 50
+
+// halvingInterval : Reward is halved every halvingInterval blocks.
+//
+//	Default is 100, could be changed by Config, e.g. HALVING_INTERVAL.
+var halvingInterval = 100
+
+// CalSubsidy : Reward of the block at height
+func CalSubsidy(height int) uint64 {
+	halvings := height / halvingInterval
+	if halvings >= 64 {
+		return 0
+	}
+	return blockSubsidy >> uint(halvings)
+}
+
+// CreateCoinbase : Create the coinbase transaction, i.e. Block.Data[0], which pays amount to recipient.
+//
+//	Coinbase has no input and no signature. Its Nonce is Height (higher 32 bits) + ExtraNonce (lower 32 bits),
+//	so that coinbase of every block has a different hash, and ExtraNonce is committed into Merkle Tree Root.
+//	Genesis Block pays nobody, i.e. recipient is nil.
+func CreateCoinbase(recipient []byte, amount uint64, height int, extraNonce uint32) *Transaction {
+	coinbase := &Transaction{
+		Nonce: uint64(height)<<32 | uint64(extraNonce),
+	}
+	if recipient != nil {
+		coinbase.Outputs = []*TxOutput{{Recipient: recipient, Amount: amount}}
+	}
+	return coinbase
+}
+
+// IsCoinbase : Check if the Transaction is a coinbase, i.e. no input, no sender, no fee and no signature
+func (tx *Transaction) IsCoinbase() bool {
+	return len(tx.Inputs) == 0 && len(tx.SenderPubKey) == 0 && len(tx.Signature) == 0 && tx.Fee == 0
+}
+
+// ParseCoinbase : Return the coinbase transaction of a block, i.e. Data[0]
+func (bk *Block) ParseCoinbase() (*Transaction, error) {
+	if len(bk.Data) == 0 {
+		return nil, fmt.Errorf("block has no data")
+	}
+	coinbase, err := DeserializeTransaction(bk.Data[0])
+	if err != nil || !coinbase.IsCoinbase() {
+		return nil, fmt.Errorf("first data is not a coinbase")
+	}
+	return coinbase, nil
+}
+
+// ValidateCoinbase : Check if Data[0] is a coinbase of the block at height, and its reward is not more than subsidy + fees
+func (bk *Block) ValidateCoinbase(height int, fees uint64) error {
+
+	coinbase, err := bk.ParseCoinbase()
+	if err != nil {
+		return err
+	}
+	if coinbase.Nonce>>32 != uint64(height) {
+		return fmt.Errorf("coinbase height is %d, expected %d", coinbase.Nonce>>32, height)
+	}
+
+	var reward uint64
+	var ok bool
+	for i := 0; i < len(coinbase.Outputs); i++ {
+		if len(coinbase.Outputs[i].Recipient) != 32 {
+			return fmt.Errorf("coinbase pays to an invalid address")
+		}
+		if reward, ok = addAmount(reward, coinbase.Outputs[i].Amount); !ok {
+			return fmt.Errorf("coinbase outputs overflow")
+		}
+	}
+	limit, ok := addAmount(CalSubsidy(height), fees)
+	if !ok || reward > limit {
+		return fmt.Errorf("coinbase pays %d, more than subsidy %d + fees %d", reward, CalSubsidy(height), fees)
+	}
+	return nil
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestValidateCoinbaseReward(t *testing.T) {
+
+	subsidy := CalSubsidy(1)
+	tests := []struct {
+		name    string
+		reward  []uint64
+		fees    uint64
+		errText string
+	}{
+		{"subsidy", []uint64{subsidy}, 0, ""},
+		{"subsidy and fees", []uint64{subsidy, 3}, 3, ""},
+		{"more than subsidy", []uint64{subsidy + 1}, 0, "more than subsidy"},
+		{"outputs wrap to subsidy", []uint64{math.MaxUint64, subsidy + 1}, 0, "outputs overflow"},
+		{"outputs wrap to zero", []uint64{1, math.MaxUint64}, 0, "outputs overflow"},
+	}
+	for _, test := range tests {
+		err := testBlock(test.reward).ValidateCoinbase(1, test.fees)
+		if !matchError(err, test.errText) {
+			t.Errorf("%s: ValidateCoinbase returns %v, expected %q", test.name, err, test.errText)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"math/big"
+	"strconv"
+)
+
+// Difficulty of Proof of Work is stored in Block Header as "Bits", a compact form of the target.
+//
+//	Bits = 0xEEMMMMMM, target = MMMMMM * 256^(EE-3)
+//	A block is valid if CurrBlockHash (as a 256-bit big endian integer) <= target.
+//
+// e.g.	0x1f00ffff == 0x0000ffff000000...00, i.e. CurrBlockHash starts with 4 hexadecimal "0"
+//
+// initialBits could be changed by Config, e.g. "--difficulty". Genesis Block depends on it, so nodes using different initialBits are on different chains.
+var initialBits uint32 = 0x1f00ffff
+
+// powLimitBits : The easiest target allowed, i.e. CurrBlockHash starts with 2 hexadecimal "0"
+const powLimitBits = 0x2000ffff
+
+// retargetInterval : Number of blocks between two difficulty adjustments. See Config.
+var retargetInterval = 10
+
+// targetBlockTime : Expected time (in second) between two blocks. See Config.
+var targetBlockTime = 10
+
+// ParseBits : Parse Bits in hexadecimal, e.g. "1f00ffff". Target must be positive and not easier than powLimitBits.
+func ParseBits(input string) (uint32, error) {
+	bits, err := strconv.ParseUint(input, 16, 32)
+	if err != nil {
+		return 0, fmt.Errorf("Bits %s is not a hexadecimal number", input)
+	}
+	target := CalTarget(uint32(bits))
+	if target.Sign() <= 0 || target.Cmp(CalTarget(powLimitBits)) > 0 {
+		return 0, fmt.Errorf("Bits %s is out of range, the easiest is %08x", input, powLimitBits)
+	}
+	return uint32(bits), nil
+}
+
+// CalTarget : Convert compact Bits to a 256-bit target
+func CalTarget(bits uint32) *big.Int {
+	exponent := uint(bits >> 24)
+	mantissa := big.NewInt(int64(bits & 0x007fffff))
+
+	// Negative target is not allowed
+	if bits&0x00800000 != 0 {
+		return big.NewInt(0)
+	}
+
+	if exponent <= 3 {
+		return mantissa.Rsh(mantissa, 8*(3-exponent))
+	}
+	return mantissa.Lsh(mantissa, 8*(exponent-3))
+}
+
+// CalBits : Convert a 256-bit target to compact Bits
+func CalBits(target *big.Int) uint32 {
+	size := uint(len(target.Bytes()))
+	var compact uint32
+
+	if size <= 3 {
+		compact = uint32(target.Uint64() << (8 * (3 - size)))
+	} else {
+		compact = uint32(new(big.Int).Rsh(target, 8*(size-3)).Uint64())
+	}
+
+	// The sign bit is set, shift the mantissa and increase the exponent
+	if compact&0x00800000 != 0 {
+		compact = compact >> 8
+		size = size + 1
+	}
+
+	return compact | uint32(size)<<24
+}
+
+// CheckHashPOW : Check if a hash is less than or equal to the target
+func CheckHashPOW(hash []byte, target *big.Int) bool {
+	return new(big.Int).SetBytes(hash).Cmp(target) <= 0
+}
+
+// CalBitsAt : Calculate the expected Bits of the block at blocks[height], using blocks before it.
+//
+//	Bits is unchanged except every retargetInterval blocks.
+//	Then target is scaled by (actual time used / expected time) of the last retargetInterval blocks.
+func CalBitsAt(blocks []*Block, height int) uint32 {
+
+	// Genesis Block uses the initial difficulty
+	if height == 0 {
+		return initialBits
+	}
+
+	// Not a retarget height, use the same Bits as previous block
+	lastBlock := blocks[height-1]
+	if height%retargetInterval != 0 {
+		return lastBlock.Bits
+	}
+
+	// Retarget height. Limit the adjustment to a factor of 4 in each direction.
+	firstBlock := blocks[height-retargetInterval]
+	expectedTime := int64((retargetInterval - 1) * targetBlockTime)
+	actualTime := int64(lastBlock.Timestamp) - int64(firstBlock.Timestamp)
+	if actualTime < expectedTime/4 {
+		actualTime = expectedTime / 4
+	}
+	if actualTime > expectedTime*4 {
+		actualTime = expectedTime * 4
+	}
+
+	target := CalTarget(lastBlock.Bits)
+	target.Mul(target, big.NewInt(actualTime))
+	target.Div(target, big.NewInt(expectedTime))
+	if target.Cmp(CalTarget(powLimitBits)) > 0 {
+		target = CalTarget(powLimitBits)
+	}
+
+	return CalBits(target)
+}
+
+// CalWork : Calculate the expected number of hashes to find a block with Bits, i.e. 2^256 / (target + 1)
+func CalWork(bits uint32) *big.Int {
+	target := CalTarget(bits)
+	if target.Sign() <= 0 {
+		return big.NewInt(0)
+	}
+	work := new(big.Int).Lsh(big.NewInt(1), 256)
+	return work.Div(work, target.Add(target, big.NewInt(1)))
+}
+
+// CalChainWork : Calculate the accumulated work of an array of blocks
+func CalChainWork(blocks []*Block) *big.Int {
+	work := big.NewInt(0)
+	for i := 0; i < len(blocks); i++ {
+		work.Add(work, CalWork(blocks[i].Bits))
+	}
+	return work
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"math/big"
+	"testing"
+)
+
+func TestCompactBitsRoundTrip(t *testing.T) {
+
+	tests := []struct {
+		bits   uint32
+		target *big.Int
+	}{
+		{0x1f00ffff, new(big.Int).Lsh(big.NewInt(0xffff), 8*28)},
+		{0x2000ffff, new(big.Int).Lsh(big.NewInt(0xffff), 8*29)},
+		{0x1d00ffff, new(big.Int).Lsh(big.NewInt(0xffff), 8*26)},
+		{0x03123456, big.NewInt(0x123456)},
+		{0x02008000, big.NewInt(0x80)},
+		{0x1e3fffc0, new(big.Int).Lsh(big.NewInt(0x3fffc0), 8*27)},
+	}
+	for _, test := range tests {
+		if target := CalTarget(test.bits); target.Cmp(test.target) != 0 {
+			t.Errorf("CalTarget(%08x) returns %x, expected %x", test.bits, target, test.target)
+		}
+		if bits := CalBits(test.target); bits != test.bits {
+			t.Errorf("CalBits(%x) returns %08x, expected %08x", test.target, bits, test.bits)
+		}
+	}
+
+	// Sign bit of mantissa means a negative target, which is not allowed
+	if target := CalTarget(0x1f80ffff); target.Sign() != 0 {
+		t.Errorf("CalTarget of a negative Bits returns %x", target)
+	}
+}
+
+func TestParseBits(t *testing.T) {
+
+	tests := []struct {
+		input string
+		bits  uint32
+		valid bool
+	}{
+		{"1f00ffff", 0x1f00ffff, true},
+		{"2000ffff", 0x2000ffff, true},
+		{"2100ffff", 0, false},
+		{"1f80ffff", 0, false},
+		{"00000000", 0, false},
+		{"xyz", 0, false},
+	}
+	for _, test := range tests {
+		bits, err := ParseBits(test.input)
+		if (err == nil) != test.valid || bits != test.bits {
+			t.Errorf("ParseBits(%s) returns %08x, %v", test.input, bits, err)
+		}
+	}
+}
+
+// testRetargetChain : Blocks of bits whose Timestamp increases by step seconds
+func testRetargetChain(count int, bits uint32, step uint32) []*Block {
+	var blocks []*Block
+	for i := 0; i < count; i++ {
+		blocks = append(blocks, &Block{Timestamp: genesisTimestamp + uint32(i)*step, Bits: bits})
+	}
+	return blocks
+}
+
+func TestCalBitsAt(t *testing.T) {
+
+	defer func(interval int, blockTime int) {
+		retargetInterval, targetBlockTime = interval, blockTime
+	}(retargetInterval, targetBlockTime)
+	retargetInterval, targetBlockTime = 5, 10
+
+	tests := []struct {
+		name   string
+		blocks []*Block
+		height int
+		bits   uint32
+	}{
+		{"genesis", nil, 0, initialBits},
+		{"not a retarget height", testRetargetChain(3, 0x1f00ffff, 1), 3, 0x1f00ffff},
+		{"on time", testRetargetChain(5, 0x1f00ffff, 10), 5, 0x1f00ffff},
+		{"too fast, limited to 4 times harder", testRetargetChain(5, 0x1f00ffff, 0), 5, 0x1e3fffc0},
+		{"too slow, limited to 4 times easier", testRetargetChain(5, 0x1f00ffff, 100), 5, 0x1f03fffc},
+		{"easier than powLimitBits", testRetargetChain(5, 0x2000ffff, 20), 5, powLimitBits},
+	}
+	for _, test := range tests {
+		if bits := CalBitsAt(test.blocks, test.height); bits != test.bits {
+			t.Errorf("%s: CalBitsAt returns %08x, expected %08x", test.name, bits, test.bits)
+		}
+	}
+}
+
+func TestCalWork(t *testing.T) {
+
+	// Target is 0xffff * 2^224, so the work is 2^256 / (0xffff * 2^224 + 1), i.e. 65537
+	if work := CalWork(0x1f00ffff); work.Cmp(big.NewInt(65537)) != 0 {
+		t.Errorf("CalWork(1f00ffff) returns %s", work)
+	}
+	if work := CalChainWork(testRetargetChain(3, 0x1f00ffff, 10)); work.Cmp(big.NewInt(3*65537)) != 0 {
+		t.Errorf("CalChainWork of 3 blocks returns %s", work)
+	}
+	if work := CalWork(0x1f80ffff); work.Sign() != 0 {
+		t.Errorf("CalWork of a negative Bits returns %s", work)
+	}
+}
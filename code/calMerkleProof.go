@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// MerkleProof : Merkle branch which proves a data is packed in a block, without downloading Block.Data
+type MerkleProof struct {
+	// Block header, so that client could check its Proof of Work and CurrBlockHash
+	Header *Block
+	// Height of the block in main chain of the node which builds the proof. It is not covered by Proof of Work.
+	Height int
+	// Leaf node to be proved, and its position in Block.Data
+	LeafHash []byte
+	Index    int
+	// Sibling hashes from leaf layer up to (but excluding) Root
+	Branch []*ProofStep
+}
+
+// ProofStep : One sibling node in a Merkle branch
+type ProofStep struct {
+	Hash []byte
+	// IsLeft is true if the sibling is on the left hand side, i.e. parent = sha256(Hash + current)
+	IsLeft bool
+}
+
+// ProofRequest : Payload of "getPF". Client provides either Data or LeafHash of the data.
+type ProofRequest struct {
+	BlockHash []byte
+	Data      []byte
+	LeafHash  []byte
+}
+
+// GenerateProof : Build a Merkle branch for data[index] using the layers of GenerateRoot
+func (n Node) GenerateProof(data [][]byte, index int) *MerkleProof {
+
+	if index < 0 || index >= len(data) {
+		return nil
+	}
+
+	layers := n.GenerateLayers(data)
+	proof := &MerkleProof{
+		LeafHash: layers[0][index].NodeHash,
+		Index:    index,
+	}
+
+	// Walk from leaf layer to the layer below Root, record the sibling in every layer
+	position := index
+	for l := 0; l < len(layers)-1; l = l + 1 {
+		if position%2 == 0 {
+			proof.Branch = append(proof.Branch, &ProofStep{Hash: layers[l][position+1].NodeHash, IsLeft: false})
+		} else {
+			proof.Branch = append(proof.Branch, &ProofStep{Hash: layers[l][position-1].NodeHash, IsLeft: true})
+		}
+		position = position / 2
+	}
+
+	return proof
+}
+
+// FindLeaf : Return the position of a leaf hash in an array of []byte. Return -1 if not found.
+func (n Node) FindLeaf(data [][]byte, leafHash []byte) int {
+	for i := 0; i < len(data); i = i + 1 {
+		if bytes.Equal(n.CalSHA256Hash(data[i]), leafHash) {
+			return i
+		}
+	}
+	return -1
+}
+
+// CalProofRoot : Recompute Merkle Tree Root from LeafHash and Branch
+func (proof *MerkleProof) CalProofRoot() []byte {
+	var n Node
+	hash := proof.LeafHash
+	for i := 0; i < len(proof.Branch); i = i + 1 {
+		if proof.Branch[i].IsLeft {
+			hash = n.CalSHA256Hash(bytes.Join([][]byte{proof.Branch[i].Hash, hash}, []byte{}))
+		} else {
+			hash = n.CalSHA256Hash(bytes.Join([][]byte{hash, proof.Branch[i].Hash}, []byte{}))
+		}
+	}
+	return hash
+}
+
+// VerifyProof : Check if the proof is valid for a block hash and a leaf hash.
+//
+//	Step 1 : Header must have valid Proof of Work, and hash to blockHash
+//	Step 2 : Branch must lead from leafHash to Root in Header
+func (proof *MerkleProof) VerifyProof(blockHash []byte, leafHash []byte) bool {
+
+	if proof == nil || proof.Header == nil {
+		return false
+	}
+
+	// Step 1 : Check the header
+	chkBk := proof.Header.CopyHeader()
+	chkBk.Serialize()
+	chkBk.CalCurrHash()
+	if !chkBk.ValidateBlock() || !bytes.Equal(chkBk.CurrBlockHash, blockHash) {
+		return false
+	}
+
+	// Step 2 : Check the branch
+	if !bytes.Equal(proof.LeafHash, leafHash) {
+		return false
+	}
+	return bytes.Equal(proof.CalProofRoot(), chkBk.Root)
+}
+
+// PrintProof : Print a Merkle branch in command line interface
+func (proof *MerkleProof) PrintProof() {
+	fmt.Printf("Tree:	Merkle Proof\n")
+	fmt.Printf("	> Block Hash	: %x\n", proof.Header.CurrBlockHash)
+	fmt.Printf("	> Block Height	: %d (reported by node)\n", proof.Height)
+	fmt.Printf("	> Root		: %x\n", proof.Header.Root)
+	fmt.Printf("	> Leaf Hash	: %x\n", proof.LeafHash)
+	fmt.Printf("	> Leaf Index	: %d\n", proof.Index)
+	for i := 0; i < len(proof.Branch); i = i + 1 {
+		if proof.Branch[i].IsLeft {
+			fmt.Printf("	> Branch[%d]	: L %x\n", i, proof.Branch[i].Hash)
+		} else {
+			fmt.Printf("	> Branch[%d]	: R %x\n", i, proof.Branch[i].Hash)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"testing"
+)
+
+func TestMerkleProofRoundTrip(t *testing.T) {
+
+	var n Node
+	for size := 1; size <= 9; size++ {
+		var data [][]byte
+		for i := 0; i < size; i++ {
+			data = append(data, []byte(fmt.Sprintf("data %d", i)))
+		}
+		root := CalRoot(data)
+		for index := 0; index < size; index++ {
+			proof := n.GenerateProof(data, index)
+			if proof == nil || !bytes.Equal(proof.LeafHash, n.CalSHA256Hash(data[index])) {
+				t.Fatalf("%d leaves: GenerateProof(%d) returns %v", size, index, proof)
+			}
+
+			// Proof is sent in JSON
+			proofJSON, _ := json.Marshal(proof)
+			var received *MerkleProof
+			if err := json.Unmarshal(proofJSON, &received); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(received.CalProofRoot(), root) {
+				t.Errorf("%d leaves: proof of leaf %d leads to %x, expected %x", size, index, received.CalProofRoot(), root)
+			}
+			if n.FindLeaf(data, proof.LeafHash) != index {
+				t.Errorf("%d leaves: FindLeaf of leaf %d returns %d", size, index, n.FindLeaf(data, proof.LeafHash))
+			}
+		}
+		if n.GenerateProof(data, size) != nil || n.GenerateProof(data, -1) != nil {
+			t.Errorf("%d leaves: GenerateProof out of range is not nil", size)
+		}
+	}
+}
+
+func TestVerifyProof(t *testing.T) {
+
+	var n Node
+	block := CreateBlock(CreateCoinbase(nil, 0, 1, 0), [][]byte{[]byte("a"), []byte("b")}, GenesisHash(), initialBits)
+	leafHash := n.CalSHA256Hash(block.Data[2])
+
+	proof := n.GenerateProof(block.Data, 2)
+	proof.Header = block.CopyHeader()
+	if !proof.VerifyProof(block.CurrBlockHash, leafHash) {
+		t.Fatalf("VerifyProof of a valid proof returns false")
+	}
+
+	// Any changed part makes the proof invalid
+	if proof.VerifyProof(GenesisHash(), leafHash) {
+		t.Errorf("VerifyProof with another block hash returns true")
+	}
+	if proof.VerifyProof(block.CurrBlockHash, n.CalSHA256Hash(block.Data[1])) {
+		t.Errorf("VerifyProof with another leaf hash returns true")
+	}
+	proof.Branch[0].Hash = n.CalSHA256Hash([]byte("c"))
+	if proof.VerifyProof(block.CurrBlockHash, leafHash) {
+		t.Errorf("VerifyProof with a changed branch returns true")
+	}
+	proof = n.GenerateProof(block.Data, 2)
+	proof.Header = block.CopyHeader()
+	proof.Header.Root = CalRoot(block.Data[1:])
+	if proof.VerifyProof(block.CurrBlockHash, leafHash) {
+		t.Errorf("VerifyProof with a changed header returns true")
+	}
+}
@@ -34,6 +34,48 @@ func (n Node) CalSHA256Hash(input []byte) []byte {
 // GenerateRoot : Create Merkle Tree
 func (n Node) GenerateRoot(data [][]byte, printFlag bool) (RootNode *Node) {
 
+	layers := n.GenerateLayers(data)
+
+	// Print the leaf node if printFlag is ON
+	if printFlag == true {
+		fmt.Printf("\nLeaf Nodes: \n")
+		for i := 0; i < len(data); i = i + 1 {
+			fmt.Printf("%x, [%s]\n", layers[0][i].NodeHash, layers[0][i].NodeData)
+		}
+	}
+
+	// Print the upper node if printFlag is ON
+	for l := 1; l < len(layers); l = l + 1 {
+		if printFlag == true {
+			fmt.Printf("\nUpper Nodes: \n")
+			for i := 0; i < len(layers[l]); i = i + 1 {
+				fmt.Printf(" Hash[%d]	%x\n", i, layers[l][i].NodeHash)
+				fmt.Printf(" Data[%d]	%x\n", i, layers[l][i].NodeData)
+			}
+		}
+	}
+	if printFlag == true {
+		fmt.Printf("\n")
+	}
+
+	// Empty data has no tree, its Root is an empty node
+	if len(layers) == 0 {
+		return &Node{}
+	}
+	RootNode = layers[len(layers)-1][0]
+	return RootNode
+}
+
+// GenerateLayers : Create Merkle Tree, and keep every layer of it.
+//					layers[0] is the leaf nodes, layers[len(layers)-1] only contains the Root node.
+//					Every layer below Root has even number of nodes, i.e. the last node is replicated if necessary.
+//					Return no layer if data is empty.
+func (n Node) GenerateLayers(data [][]byte) (layers [][]*Node) {
+
+	if len(data) == 0 {
+		return nil
+	}
+
 	// Prepare leaf nodes
 	var nodes []*Node
 
@@ -44,17 +86,9 @@ func (n Node) GenerateRoot(data [][]byte, printFlag bool) (RootNode *Node) {
 		})
 	}
 
-	// Print the leaf node if printFlag is ON
-	if printFlag == true {
-		fmt.Printf("\nLeaf Nodes: \n")
-		for i := 0; i < len(nodes); i = i + 1 {
-			fmt.Printf("%x, [%s]\n", nodes[i].NodeHash, nodes[i].NodeData)
-		}
-	}
-
 	// Build tree from bottom layer
 	for {
-		if len(nodes) != 1 {
+		if len(nodes) > 1 {
 
 			// If the number of node in bottom layer is not even, replicate and push the last node
 			if len(nodes)%2 == 1 {
@@ -63,6 +97,7 @@ func (n Node) GenerateRoot(data [][]byte, printFlag bool) (RootNode *Node) {
 					NodeHash: nodes[len(nodes)-1].NodeHash,
 				})
 			}
+			layers = append(layers, nodes)
 
 			// Build the upper level nodes
 			var upperNodes []*Node
@@ -77,22 +112,10 @@ func (n Node) GenerateRoot(data [][]byte, printFlag bool) (RootNode *Node) {
 			// Continue tree building until Root node is reached, i.e. len(nodes) == 1
 			nodes = upperNodes
 
-			// Print the upper node if printFlag is ON
-			if printFlag == true {
-				fmt.Printf("\nUpper Nodes: \n")
-				for i := 0; i < len(nodes); i = i + 1 {
-					fmt.Printf(" Hash[%d]	%x\n", i, nodes[i].NodeHash)
-					fmt.Printf(" Data[%d]	%x\n", i, nodes[i].NodeData)
-				}
-			}
-
 		} else {
-			if printFlag == true {
-				fmt.Printf("\n")
-			}
+			layers = append(layers, nodes)
 			break
 		}
 	}
-	RootNode = nodes[0]
-	return RootNode
+	return layers
 }
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestCalRootEmpty(t *testing.T) {
+
+	var n Node
+	if layers := n.GenerateLayers(nil); len(layers) != 0 {
+		t.Errorf("GenerateLayers of empty data returns %d layer(s)", len(layers))
+	}
+	if root := CalRoot([][]byte{}); len(root) != 0 {
+		t.Errorf("CalRoot of empty data returns %x", root)
+	}
+}
+
+func TestCalRoot(t *testing.T) {
+
+	var n Node
+	a, b, c := []byte("a"), []byte("b"), []byte("c")
+	hashAB := n.CalSHA256Hash(append(n.CalSHA256Hash(a), n.CalSHA256Hash(b)...))
+	hashCC := n.CalSHA256Hash(append(n.CalSHA256Hash(c), n.CalSHA256Hash(c)...))
+
+	tests := []struct {
+		name string
+		data [][]byte
+		root []byte
+	}{
+		{"one leaf", [][]byte{a}, n.CalSHA256Hash(a)},
+		{"two leaves", [][]byte{a, b}, hashAB},
+		{"last leaf replicated", [][]byte{a, b, c}, n.CalSHA256Hash(append(hashAB, hashCC...))},
+	}
+	for _, test := range tests {
+		if root := CalRoot(test.data); !bytes.Equal(root, test.root) {
+			t.Errorf("%s: CalRoot returns %x, expected %x", test.name, root, test.root)
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// minerThreads : Number of worker goroutines used in Proof of Work.
+//
+//	Default is the number of CPU, could be changed by Config, e.g. MINER_THREADS.
+var minerThreads = runtime.NumCPU()
+
+// MiningStats : Statistics of a Proof of Work
+type MiningStats struct {
+	Hashes   uint64
+	Duration time.Duration
+}
+
+// Hashrate : Number of hashes per second
+func (ms MiningStats) Hashrate() float64 {
+	if ms.Duration <= 0 {
+		return 0
+	}
+	return float64(ms.Hashes) / ms.Duration.Seconds()
+}
+
+// minerCheckInterval : Number of hashes between two checks of cancellation
+const minerCheckInterval = 4096
+
+// maxFutureBlockTime : Timestamp of a block could not be later than current time + maxFutureBlockTime (in second)
+const maxFutureBlockTime = 2 * 60 * 60
+
+// CalNoncePOWParallel : Proof of Work using multiple goroutines.
+//
+//	The 32-bit nonce space is splitted into workers ranges. Each worker changes the Nonce bytes in its own copy of the header,
+//	and compares CurrBlockHash with the target bytes directly.
+//	Return true if a valid Nonce is found. Return false if cancel is closed, or the whole nonce space is tried.
+func (bk *Block) CalNoncePOWParallel(workers int, cancel <-chan struct{}) (bool, MiningStats) {
+
+	if workers <= 0 {
+		workers = 1
+	}
+	startTime := time.Now()
+	bk.Serialize()
+	header := bk.ByteStream
+	targetBytes := CalTarget(bk.Bits).FillBytes(make([]byte, 32))
+
+	var hashes uint64
+	var stopFlag int32
+	var wg sync.WaitGroup
+	found := make(chan uint32, workers)
+
+	rangeSize := (uint64(1) << 32) / uint64(workers)
+	for w := 0; w < workers; w++ {
+		start := uint64(w) * rangeSize
+		end := start + rangeSize
+		if w == workers-1 {
+			end = uint64(1) << 32
+		}
+
+		wg.Add(1)
+		go func(start uint64, end uint64) {
+			defer wg.Done()
+
+			// Nonce is the last 4 bytes of the header
+			tryHeader := make([]byte, len(header))
+			copy(tryHeader, header)
+			var count uint64
+			for nonce := start; nonce < end; nonce++ {
+				binary.BigEndian.PutUint32(tryHeader[len(tryHeader)-4:], uint32(nonce))
+				hash := sha256.Sum256(tryHeader[4:])
+				count++
+				if bytes.Compare(hash[:], targetBytes) <= 0 {
+					atomic.StoreInt32(&stopFlag, 1)
+					found <- uint32(nonce)
+					break
+				}
+				if count%minerCheckInterval == 0 {
+					if atomic.LoadInt32(&stopFlag) == 1 {
+						break
+					}
+					select {
+					case <-cancel:
+						atomic.StoreInt32(&stopFlag, 1)
+					default:
+					}
+				}
+			}
+			atomic.AddUint64(&hashes, count)
+		}(start, end)
+	}
+	wg.Wait()
+	close(found)
+
+	stats := MiningStats{Hashes: atomic.LoadUint64(&hashes), Duration: time.Since(startTime)}
+	nonce, ok := <-found
+	if !ok {
+		return false, stats
+	}
+
+	// Rebuild the header and CurrBlockHash with the winning Nonce
+	bk.Nonce = nonce
+	bk.Serialize()
+	bk.CalCurrHash()
+	return true, stats
+}
+
+// CalNoncePOWRolling : Proof of Work which continues after the 32-bit nonce space is exhausted.
+//
+//	Step 1 : Search the whole nonce space with CalNoncePOWParallel.
+//	Step 2 : If no Nonce is valid, Timestamp + 1 and search again, until Timestamp reaches current time + maxFutureBlockTime.
+//	Step 3 : Then ExtraNonce + 1, i.e. Merkle Tree Root is changed, and restart from current time.
+//	Return false only if cancel is closed.
+func (bk *Block) CalNoncePOWRolling(workers int, cancel <-chan struct{}) (bool, MiningStats) {
+
+	var total MiningStats
+	for {
+		foundFlag, stats := bk.CalNoncePOWParallel(workers, cancel)
+		total.Hashes = total.Hashes + stats.Hashes
+		total.Duration = total.Duration + stats.Duration
+		if foundFlag {
+			return true, total
+		}
+
+		// Nonce space is not exhausted, i.e. mining is cancelled
+		select {
+		case <-cancel:
+			return false, total
+		default:
+		}
+
+		if int64(bk.Timestamp)+1 <= time.Now().Unix()+maxFutureBlockTime {
+			bk.Timestamp = bk.Timestamp + 1
+		} else {
+			bk.SetExtraNonce(bk.ExtraNonce + 1)
+			bk.Timestamp = uint32(time.Now().Unix())
+		}
+	}
+}
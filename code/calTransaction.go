@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+)
+
+// Transaction : Transfer signed by the sender using ECDSA (P-256).
+//
+//	Transaction is packed in Block.Data in JSON. Its hash, i.e. sha256(JSON), is the leaf node of Merkle Tree.
+//	Inputs spend unspent outputs of previous transactions owned by the sender. Outputs create new unspent outputs.
+type Transaction struct {
+	// Public key of sender, in PKIX DER
+	SenderPubKey []byte
+	Inputs       []*TxInput
+	Outputs      []*TxOutput
+	Nonce        uint64
+	// Fee declared by sender, i.e. inputs - outputs. Optional, 0 means not declared.
+	//	Declared fee is checked against inputs - outputs when the transaction is applied to UTXO set.
+	Fee uint64 `json:",omitempty"`
+	// ASN.1 DER signature of SigningHash()
+	Signature []byte
+}
+
+// TxInput : Reference to an output of a previous transaction
+type TxInput struct {
+	PrevTxHash []byte
+	OutIndex   int
+}
+
+// TxOutput : Amount transferred to a recipient
+type TxOutput struct {
+	// Address of recipient, i.e. sha256(public key of recipient in PKIX DER)
+	Recipient []byte
+	Amount    uint64
+}
+
+// CreateTransaction : Create a new Transaction paying fee, and sign it using the private key of sender
+func CreateTransaction(sender *ecdsa.PrivateKey, inputs []*TxInput, outputs []*TxOutput, fee uint64) (*Transaction, error) {
+
+	pubKey, err := x509.MarshalPKIXPublicKey(&sender.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	tx := &Transaction{
+		SenderPubKey: pubKey,
+		Inputs:       inputs,
+		Outputs:      outputs,
+		Nonce:        CalRandomNonce(),
+		Fee:          fee,
+	}
+	err = tx.Sign(sender)
+	return tx, err
+}
+
+// CalRandomNonce : Random 64-bit nonce, so that two transactions with the same content have different hashes
+func CalRandomNonce() uint64 {
+	buf := make([]byte, 8)
+	rand.Read(buf)
+	return binary.BigEndian.Uint64(buf)
+}
+
+// Serialize : Serialize the Transaction in JSON. It is the data packed in Block.Data
+func (tx *Transaction) Serialize() []byte {
+	txJSON, _ := json.Marshal(tx)
+	return txJSON
+}
+
+// DeserializeTransaction : Convert a data in Block.Data to Transaction
+//							Data must be the same as Serialize of the Transaction, so that Hash is the leaf of data in Merkle Tree.
+func DeserializeTransaction(data []byte) (*Transaction, error) {
+	var tx *Transaction
+	err := json.Unmarshal(data, &tx)
+	if err == nil && tx == nil {
+		err = fmt.Errorf("empty transaction")
+	}
+	if err == nil && !bytes.Equal(tx.Serialize(), data) {
+		err = fmt.Errorf("transaction is not in canonical form")
+	}
+	return tx, err
+}
+
+// Hash : Hash of the Transaction, i.e. leaf node of Merkle Tree
+func (tx *Transaction) Hash() []byte {
+	var n Node
+	return n.CalSHA256Hash(tx.Serialize())
+}
+
+// SigningHash : Hash of the Transaction without Signature. This is the message to be signed.
+func (tx *Transaction) SigningHash() []byte {
+	unsigned := *tx
+	unsigned.Signature = nil
+	hash := sha256.Sum256(unsigned.Serialize())
+	return hash[:]
+}
+
+// Sign : Sign the Transaction using the private key of sender
+func (tx *Transaction) Sign(sender *ecdsa.PrivateKey) error {
+	signature, err := ecdsa.SignASN1(rand.Reader, sender, tx.SigningHash())
+	if err != nil {
+		return err
+	}
+	tx.Signature = signature
+	return nil
+}
+
+// VerifySignature : Check if the Transaction is well-formed and signed by the owner of SenderPubKey
+func (tx *Transaction) VerifySignature() bool {
+
+	if len(tx.Signature) == 0 || len(tx.Inputs) == 0 || len(tx.Outputs) == 0 {
+		return false
+	}
+	for i := 0; i < len(tx.Outputs); i++ {
+		if len(tx.Outputs[i].Recipient) != sha256.Size || tx.Outputs[i].Amount == 0 {
+			return false
+		}
+	}
+	pubKey, err := x509.ParsePKIXPublicKey(tx.SenderPubKey)
+	if err != nil {
+		return false
+	}
+	ecdsaPubKey, ok := pubKey.(*ecdsa.PublicKey)
+	if !ok {
+		return false
+	}
+	return ecdsa.VerifyASN1(ecdsaPubKey, tx.SigningHash(), tx.Signature)
+}
+
+// SenderAddress : Address of the sender, i.e. sha256(SenderPubKey)
+func (tx *Transaction) SenderAddress() []byte {
+	hash := sha256.Sum256(tx.SenderPubKey)
+	return hash[:]
+}
+
+// ValidateTransactions : Check if every data in Block.Data, except the coinbase data Data[0], is a correctly signed Transaction.
+func (bk *Block) ValidateTransactions() bool {
+	for i := 1; i < len(bk.Data); i++ {
+		tx, err := DeserializeTransaction(bk.Data[i])
+		if err != nil || tx.VerifySignature() == false {
+			return false
+		}
+	}
+	return true
+}
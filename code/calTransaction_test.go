@@ -0,0 +1,37 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestDeserializeTransactionCanonical(t *testing.T) {
+
+	_, key, fundHash := testUTXOSet(t, 10)
+	tx, err := CreateTransaction(key, []*TxInput{{PrevTxHash: fundHash, OutIndex: 0}}, []*TxOutput{{Recipient: make([]byte, 32), Amount: 9}}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := tx.Serialize()
+	var n Node
+
+	// Canonical data, Hash is the leaf of data
+	got, err := DeserializeTransaction(data)
+	if err != nil {
+		t.Fatalf("DeserializeTransaction of canonical data returns %v", err)
+	}
+	if !bytes.Equal(got.Hash(), n.CalSHA256Hash(data)) {
+		t.Errorf("Hash is not the leaf of data")
+	}
+
+	// Same transaction with other whitespace or key order
+	others := [][]byte{
+		append([]byte(" "), data...),
+		bytes.Replace(data, []byte(`,"Inputs"`), []byte(`, "Inputs"`), 1),
+	}
+	for i := 0; i < len(others); i++ {
+		if _, err := DeserializeTransaction(others[i]); !matchError(err, "canonical") {
+			t.Errorf("DeserializeTransaction of data #%d returns %v", i, err)
+		}
+	}
+}
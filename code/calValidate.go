@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// RejectReason : Why a block is rejected. It is short and stable, so that it could be checked by programs and shown to users.
+type RejectReason string
+
+// Reasons of rejecting a block
+const (
+	RejectMalformed   RejectReason = "malformed"    // Data could not be parsed, e.g. no coinbase
+	RejectTooLarge    RejectReason = "too-large"    // Data is larger than maxBlockDataSize
+	RejectBadPoW      RejectReason = "bad-pow"      // CurrBlockHash is not the hash of the header, or more than the target of Bits
+	RejectBadBits     RejectReason = "bad-bits"     // Bits is not the expected difficulty at this height
+	RejectDuplicate   RejectReason = "duplicate"    // Block is already known
+	RejectBadPrev     RejectReason = "bad-prev"     // PrevBlockHash is not the parent, or the parent is unknown
+	RejectBadMerkle   RejectReason = "bad-merkle"   // Root is not the Merkle Tree Root of Data
+	RejectBadTx       RejectReason = "bad-tx"       // A transaction is not correctly signed, or could not be applied to UTXO set
+	RejectBadCoinbase RejectReason = "bad-coinbase" // Coinbase is not of this height, or pays more than subsidy + fees
+	RejectTimeTooOld  RejectReason = "time-too-old" // Timestamp is not later than median time past
+	RejectTimeTooNew  RejectReason = "time-too-new" // Timestamp is later than current time + maxFutureBlockTime
+)
+
+// BlockError : Error of an invalid block, with the reason of rejection
+type BlockError struct {
+	Reason RejectReason
+	Detail string
+}
+
+func (e *BlockError) Error() string {
+	return string(e.Reason) + ", " + e.Detail
+}
+
+// rejectBlock : Return a BlockError with reason and formatted detail
+func rejectBlock(reason RejectReason, format string, a ...interface{}) error {
+	return &BlockError{Reason: reason, Detail: fmt.Sprintf(format, a...)}
+}
+
+// CheckHeader : Check Proof of Work of the header.
+//				 CurrBlockHash must be the hash of the header, and not more than the target of Bits. Bits must not be easier than powLimitBits.
+func (bk *Block) CheckHeader() error {
+
+	chkBk := bk.CopyHeader()
+	chkBk.Serialize()
+	chkBk.CalCurrHash()
+	if len(bk.CurrBlockHash) > 0 && string(bk.CurrBlockHash) != string(chkBk.CurrBlockHash) {
+		return rejectBlock(RejectBadPoW, "CurrBlockHash %x does not match the header", bk.CurrBlockHash)
+	}
+
+	target := CalTarget(chkBk.Bits)
+	if target.Sign() <= 0 || target.Cmp(CalTarget(powLimitBits)) > 0 {
+		return rejectBlock(RejectBadPoW, "Bits %08x is out of range", chkBk.Bits)
+	}
+	if CheckHashPOW(chkBk.CurrBlockHash, target) == false {
+		return rejectBlock(RejectBadPoW, "hash %x is more than the target of Bits %08x", chkBk.CurrBlockHash, chkBk.Bits)
+	}
+	return nil
+}
+
+// CheckData :	Check Data against the header. Header only block has nothing to check.
+//				Data must not be larger than maxBlockDataSize, Data[0] must be the coinbase of ExtraNonce,
+//				and Root must be the Merkle Tree Root of Data.
+func (bk *Block) CheckData() error {
+
+	if len(bk.Data) == 0 {
+		return nil
+	}
+	size := 0
+	for i := 0; i < len(bk.Data); i++ {
+		size = size + len(bk.Data[i])
+	}
+	if size > maxBlockDataSize {
+		return rejectBlock(RejectTooLarge, "data is %d bytes, limit is %d", size, maxBlockDataSize)
+	}
+	coinbase, err := bk.ParseCoinbase()
+	if err != nil {
+		return rejectBlock(RejectMalformed, "%s", err)
+	}
+	if uint32(coinbase.Nonce) != bk.ExtraNonce {
+		return rejectBlock(RejectMalformed, "coinbase does not commit ExtraNonce %d", bk.ExtraNonce)
+	}
+	if string(CalRoot(bk.Data)) != string(bk.Root) {
+		return rejectBlock(RejectBadMerkle, "Root %x is not the Merkle Tree Root of data", bk.Root)
+	}
+	return nil
+}
+
+// CheckBlock : Check everything of a block which does not depend on the chain, i.e. CheckHeader, CheckData and signatures of transactions.
+//				Data of Genesis Block is not transactions, so signatures are not checked.
+func (bk *Block) CheckBlock() error {
+
+	if err := bk.CheckHeader(); err != nil {
+		return err
+	}
+	if err := bk.CheckData(); err != nil {
+		return err
+	}
+	if !isGenesisBlock(bk) && bk.ValidateTransactions() == false {
+		return rejectBlock(RejectBadTx, "a transaction is not correctly signed")
+	}
+	return nil
+}
+
+// CheckBlockContext :	Check a block at height against its ancestors, i.e. blocks[:height].
+//
+//	The block at height 0 must be Genesis Block. PrevBlockHash must be the hash of blocks[height-1], Bits must be the expected difficulty (see CalBitsAt),
+//	Timestamp must be later than median time past, and not later than current time + maxFutureBlockTime.
+func CheckBlockContext(newBlock *Block, blocks []*Block, height int) error {
+
+	if height == 0 && !isGenesisBlock(newBlock) {
+		return rejectBlock(RejectBadPrev, "block %x at height 0 is not Genesis Block", newBlock.CurrBlockHash)
+	}
+	if height > 0 && string(newBlock.PrevBlockHash) != string(blocks[height-1].CurrBlockHash) {
+		return rejectBlock(RejectBadPrev, "PrevBlockHash %x is not the block at height %d", newBlock.PrevBlockHash, height-1)
+	}
+	if bits := CalBitsAt(blocks, height); newBlock.Bits != bits {
+		return rejectBlock(RejectBadBits, "Bits is %08x, expected %08x", newBlock.Bits, bits)
+	}
+	if mtp := CalMedianTimePast(blocks, height); height > 0 && newBlock.Timestamp <= mtp {
+		return rejectBlock(RejectTimeTooOld, "Timestamp %d is not later than median time past %d", newBlock.Timestamp, mtp)
+	}
+	if maxTime := time.Now().Unix() + maxFutureBlockTime; int64(newBlock.Timestamp) > maxTime {
+		return rejectBlock(RejectTimeTooNew, "Timestamp %d is later than %d", newBlock.Timestamp, maxTime)
+	}
+	return nil
+}
@@ -0,0 +1,24 @@
+package main
+
+import "testing"
+
+func TestCheckBlockContextGenesis(t *testing.T) {
+
+	genesis := CreateGenesisBlock()
+	if !isGenesisBlock(genesis) {
+		t.Fatalf("Genesis Block %x is not GenesisHash %x", genesis.CurrBlockHash, GenesisHash())
+	}
+	if err := CheckBlockContext(genesis, nil, 0); err != nil {
+		t.Errorf("CheckBlockContext of Genesis Block returns %v", err)
+	}
+
+	// Another block with PrevBlockHash all "0" is not Genesis Block
+	other := PrepareBlock(CreateCoinbase(nil, 0, 0, 0), nil, make([]byte, 32), initialBits)
+	other.CalNoncePOW()
+	if isGenesisBlock(other) {
+		t.Fatalf("Block %x is taken as Genesis Block", other.CurrBlockHash)
+	}
+	if err := CheckBlockContext(other, nil, 0); !matchError(err, "not Genesis Block") {
+		t.Errorf("CheckBlockContext of another block at height 0 returns %v", err)
+	}
+}
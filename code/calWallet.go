@@ -0,0 +1,55 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"os"
+)
+
+// LoadWallet : Load the private key of userID from <dataDir>/wallet_<userID>.pem. Create a new key if it does not exist.
+func LoadWallet(userID string) *ecdsa.PrivateKey {
+
+	walletPath := dataPath("wallet_" + userID + ".pem")
+
+	// Read the key from disk
+	pemBytes, err := ioutil.ReadFile(walletPath)
+	if err == nil {
+		block, _ := pem.Decode(pemBytes)
+		if block != nil {
+			key, err := x509.ParseECPrivateKey(block.Bytes)
+			if err == nil {
+				return key
+			}
+		}
+		fmt.Println("Wallet:	Cannot read wallet,", walletPath)
+		return nil
+	}
+
+	// Create a new key, and save it on disk
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		fmt.Println("Wallet:	Cannot create key,", err)
+		return nil
+	}
+	keyBytes, _ := x509.MarshalECPrivateKey(key)
+	os.MkdirAll(dataDir, os.ModePerm)
+	err = ioutil.WriteFile(walletPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyBytes}), 0600)
+	if err != nil {
+		fmt.Println("Wallet:	Cannot save wallet,", err)
+	}
+	fmt.Println("Wallet:	New wallet is created,", walletPath)
+	return key
+}
+
+// CalAddress : Address of a public key, i.e. sha256(public key in PKIX DER)
+func CalAddress(pubKey *ecdsa.PublicKey) []byte {
+	pubKeyBytes, _ := x509.MarshalPKIXPublicKey(pubKey)
+	hash := sha256.Sum256(pubKeyBytes)
+	return hash[:]
+}
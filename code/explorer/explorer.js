@@ -0,0 +1,192 @@
+// Web Explorer : Single page using HTTP API of the node (see nodeHTTP.go). Pages are selected by location.hash.
+//
+//	#/				Latest blocks of main chain
+//	#/block/<id>	Block detail, id is a height or CurrBlockHash
+
+"use strict";
+
+// Fields of the serialized Block Header, offset and size in bytes. See Block.Serialize.
+const headerFields = [
+	{ name: "MagicNumber", css: "field-magic", offset: 0, size: 4 },
+	{ name: "Timestamp", css: "field-timestamp", offset: 4, size: 4 },
+	{ name: "PrevBlockHash", css: "field-prev", offset: 8, size: 32 },
+	{ name: "MerkleTreeRoot", css: "field-root", offset: 40, size: 32 },
+	{ name: "Bits", css: "field-bits", offset: 72, size: 4 },
+	{ name: "Nonce", css: "field-nonce", offset: 76, size: 4 },
+];
+
+const recentCount = 20;
+
+const content = document.getElementById("content");
+
+// api : GET path of HTTP API. Throw the Error of the reply if it fails.
+async function api(path) {
+	const response = await fetch(path);
+	const reply = await response.json();
+	if (!response.ok) {
+		throw new Error(reply.Error || response.statusText);
+	}
+	return reply;
+}
+
+// escapeHTML : Data in blocks is given by users, never insert it as HTML
+function escapeHTML(text) {
+	return String(text).replace(/[&<>"']/g, (c) => "&#" + c.charCodeAt(0) + ";");
+}
+
+// bytesOf : []byte fields are in base64 in JSON
+function bytesOf(base64) {
+	return Uint8Array.from(atob(base64 || ""), (c) => c.charCodeAt(0));
+}
+
+function hexOf(base64) {
+	return Array.from(bytesOf(base64), (b) => b.toString(16).padStart(2, "0")).join("");
+}
+
+// textOf : Data in UTF-8. Transactions are in JSON, show them indented.
+function textOf(base64) {
+	const text = new TextDecoder().decode(bytesOf(base64));
+	try {
+		return JSON.stringify(JSON.parse(text), null, 2);
+	} catch (e) {
+		return text;
+	}
+}
+
+function timeOf(timestamp) {
+	return new Date(timestamp * 1000).toISOString().replace("T", " ").replace(".000Z", " UTC");
+}
+
+function bitsOf(bits) {
+	return bits.toString(16).padStart(8, "0");
+}
+
+function blockLink(hash, text) {
+	return '<a class="hash" href="#/block/' + escapeHTML(hash) + '">' + escapeHTML(text || hash) + "</a>";
+}
+
+function showError(err) {
+	content.innerHTML = '<p class="error">' + escapeHTML(err.message) + "</p>";
+}
+
+// showRecent : Latest blocks of main chain, and a summary of the node
+async function showRecent() {
+	const [blocks, mempool, peers] = await Promise.all([
+		api("/api/blocks?count=" + recentCount),
+		api("/api/mempool"),
+		api("/api/peers"),
+	]);
+
+	let html = '<p class="summary">';
+	html += "<span>Height: " + (blocks.length > 0 ? blocks[0].Height : "-") + "</span>";
+	html += "<span>Pending transactions: " + mempool.length + "</span>";
+	html += "<span>Peers: " + peers.length + "</span></p>";
+
+	html += "<h2>Latest Blocks</h2><table><tr><th>Height</th><th>Block Hash</th><th>Timestamp</th><th>Bits</th><th>Nonce</th></tr>";
+	for (const info of blocks) {
+		html += "<tr><td>" + info.Height + "</td><td>" + blockLink(info.Hash) + "</td><td>" + timeOf(info.Block.Timestamp) +
+			"</td><td>" + bitsOf(info.Block.Bits) + "</td><td>" + info.Block.Nonce + "</td></tr>";
+	}
+	html += "</table>";
+	content.innerHTML = html;
+}
+
+// showBlock : Block detail, byte layout of Block Header, data and Merkle Tree
+async function showBlock(id) {
+	const info = await api("/api/block/" + encodeURIComponent(id));
+	const block = info.Block;
+	const prevHash = hexOf(block.PrevBlockHash);
+
+	let html = "<h2>Block " + (info.MainChain ? info.Height : "(side branch)") + "</h2><table>";
+	html += "<tr><th>Block Hash</th><td class=\"hash\">" + escapeHTML(info.Hash) + "</td></tr>";
+	html += "<tr><th>Previous Block</th><td>" + (/^0*$/.test(prevHash) ? '<span class="hash">' + prevHash + "</span>" : blockLink(prevHash)) + "</td></tr>";
+	html += "<tr><th>Merkle Tree Root</th><td class=\"hash\">" + hexOf(block.Root) + "</td></tr>";
+	html += "<tr><th>Timestamp</th><td>" + block.Timestamp + " (" + timeOf(block.Timestamp) + ")</td></tr>";
+	html += "<tr><th>Bits</th><td>" + bitsOf(block.Bits) + "</td></tr>";
+	html += "<tr><th>Nonce</th><td>" + block.Nonce + "</td></tr>";
+	html += "<tr><th>ExtraNonce</th><td>" + block.ExtraNonce + "</td></tr>";
+	html += "<tr><th>Main Chain</th><td>" + (info.MainChain ? "Yes" : "No") + "</td></tr>";
+	html += "</table>";
+
+	html += "<h2>Block Header in Byte Stream (80 bytes)</h2>" + headerLayout(info.Header);
+
+	if (!block.Data || block.Data.length === 0) {
+		html += "<h2>Data</h2><p>Data of the block is not downloaded by this node.</p>";
+		content.innerHTML = html;
+		return;
+	}
+
+	const tree = await api("/api/tree/" + encodeURIComponent(info.Hash));
+	html += "<h2>Merkle Tree</h2>" + treeLayout(tree);
+
+	html += "<h2>Data (" + block.Data.length + ")</h2><table><tr><th>#</th><th>Leaf Hash</th><th>Data</th></tr>";
+	for (let i = 0; i < block.Data.length; i++) {
+		html += "<tr><td>" + i + (i === 0 ? "<br>coinbase" : "") + '</td><td class="hash">' + tree.Layers[0][i] +
+			"</td><td><pre>" + escapeHTML(textOf(block.Data[i])) + "</pre></td></tr>";
+	}
+	html += "</table>";
+	content.innerHTML = html;
+}
+
+// headerLayout : Serialized Block Header in hexadecimal, one color per field, and a table of the fields
+function headerLayout(header) {
+	let bytes = '<p class="bytes">';
+	let table = "<table><tr><th>Field</th><th>Offset</th><th>Size</th><th>Value</th></tr>";
+	for (const field of headerFields) {
+		const value = header.substring(field.offset * 2, (field.offset + field.size) * 2);
+		bytes += '<span class="' + field.css + '" title="' + field.name + '">' + value + "</span>";
+		table += '<tr><td><span class="' + field.css + '">' + field.name + "</span></td><td>" + field.offset + "</td><td>" +
+			field.size + '</td><td class="hash">' + value + "</td></tr>";
+	}
+	return bytes + "</p>" + table + "</table>";
+}
+
+// treeLayout : Layers of Merkle Tree, Root on top. The last node of a layer with odd number of nodes is replicated (dashed).
+function treeLayout(tree) {
+	const counts = [tree.Leaves];
+	for (let l = 1; l < tree.Layers.length; l++) {
+		counts.push(Math.ceil(counts[l - 1] / 2));
+	}
+
+	let html = '<div class="tree">';
+	for (let l = tree.Layers.length - 1; l >= 0; l--) {
+		html += '<div class="layer">';
+		for (let i = 0; i < tree.Layers[l].length; i++) {
+			const hash = tree.Layers[l][i];
+			let css = "node";
+			if (l === tree.Layers.length - 1) {
+				css += " root";
+			} else if (i >= counts[l]) {
+				css += " replicated";
+			}
+			const label = l === 0 ? "#" + Math.min(i, counts[0] - 1) + " " : "";
+			html += '<span class="' + css + '" title="' + hash + '">' + label + hash.substring(0, 8) + "</span>";
+		}
+		html += "</div>";
+	}
+	return html + "</div>";
+}
+
+function route() {
+	const path = location.hash.replace(/^#/, "");
+	const match = path.match(/^\/block\/(.+)$/);
+	const page = match ? showBlock(decodeURIComponent(match[1])) : showRecent();
+	page.catch(showError);
+}
+
+// Search : height, CurrBlockHash, Merkle Tree Root or Transaction Hash
+document.getElementById("search").addEventListener("submit", (event) => {
+	event.preventDefault();
+	const query = document.getElementById("query").value.trim();
+	if (query === "") {
+		return;
+	}
+	api("/api/search?q=" + encodeURIComponent(query))
+		.then((info) => {
+			location.hash = "#/block/" + (info.MainChain ? info.Height : info.Hash);
+		})
+		.catch(showError);
+});
+
+window.addEventListener("hashchange", route);
+route();
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"embed"
+	"encoding/hex"
+	"io/fs"
+	"net/http"
+)
+
+// Web Explorer : Pages to browse the chain of a node in a web browser, served with HTTP API on httpAddr.
+//				  Files in ./explorer are embedded in the executable, so the node needs nothing else at runtime.
+//
+//	#/					Latest blocks of main chain
+//	#/block/<id>		Block detail, with the byte layout of Block Header and its Merkle Tree
+//	Search				By height, CurrBlockHash, Merkle Tree Root or Transaction Hash, i.e. /api/search
+//
+//go:embed explorer
+var explorerFiles embed.FS
+
+// TreeInfo : Reply of /api/tree. Layers are built by GenerateLayers, i.e. Layers[0] is the leaf hashes and the last layer is Root.
+//			  Leaves is the number of data, a layer with an odd number of nodes has its last node replicated.
+type TreeInfo struct {
+	Hash   string
+	Root   string
+	Leaves int
+	Layers [][]string
+}
+
+// explorerHandler : Serve the embedded files of Web Explorer
+func explorerHandler() http.Handler {
+	files, err := fs.Sub(explorerFiles, "explorer")
+	if err != nil {
+		logln(logError, "Node:	Cannot load Web Explorer,", err)
+		return http.NotFoundHandler()
+	}
+	return http.FileServer(http.FS(files))
+}
+
+func httpTree(w http.ResponseWriter, r *http.Request, state *ChainState) {
+
+	if !httpAccept(w, r, http.MethodGet) {
+		return
+	}
+	args := httpPathArgs(r, "/api/tree/")
+	if len(args) != 1 {
+		httpFail(w, http.StatusBadRequest, "usage: /api/tree/<hash|height>")
+		return
+	}
+	info, status, message := findBlock(args[0], false, state.Snapshot())
+	if info == nil {
+		httpFail(w, status, message)
+		return
+	}
+	if len(info.Block.Data) == 0 {
+		httpFail(w, http.StatusNotFound, "data of the block is not downloaded")
+		return
+	}
+
+	var n Node
+	layers := n.GenerateLayers(info.Block.Data)
+	tree := &TreeInfo{Hash: info.Hash, Root: hex.EncodeToString(info.Block.Root), Leaves: len(info.Block.Data)}
+	for l := 0; l < len(layers); l++ {
+		hashes := make([]string, len(layers[l]))
+		for i := 0; i < len(layers[l]); i++ {
+			hashes[i] = hex.EncodeToString(layers[l][i].NodeHash)
+		}
+		tree.Layers = append(tree.Layers, hashes)
+	}
+	httpReply(w, http.StatusOK, tree)
+}
@@ -0,0 +1,364 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// HTTP API : Information of the TCP protocol in handleMsg, in JSON over HTTP, so that dashboards and scripts could query
+//			  a node without speaking the raw protocol. Hashes in paths are in hexadecimal. []byte fields of Block,
+//			  MerkleProof and MempoolEntry are in base64, i.e. the same JSON as the replies of the TCP protocol.
+//
+//	GET		/api/tip					Header of the tip of main chain
+//	GET		/api/blocks?count=<n>		Headers of the latest n blocks of main chain, the tip first (n is 10 by default)
+//	GET		/api/block/<hash|height>	Block by CurrBlockHash (main chain or side branch), or by height of main chain
+//	GET		/api/search?q=<query>		Block by height, CurrBlockHash, Merkle Tree Root or hash of a data in it
+//	GET		/api/tree/<hash|height>		Every layer of the Merkle Tree of a block, see nodeExplorer.go
+//	GET		/api/tx/<hash>				Transaction by its hash (leaf hash), in main chain or in Mempool
+//	GET		/api/proof/<hash>/<leaf>	Merkle Proof of a leaf hash in a block, same as "getPF".
+//										Raw data could be given as /api/proof/<hash>?data=<data> instead.
+//	GET		/api/proof/<leaf>			Merkle Proof of a leaf hash, the block is found by the leaf index. Same as "getLF".
+//										Raw data could be given as /api/proof?data=<data> instead.
+//	GET		/api/mempool				Pending transactions, same as "getMP"
+//	GET		/api/peers					Known peers, including failing and banned ones
+//	POST	/api/tx						Submit a transaction, body is the serialized Transaction. Same as "subTX".
+//
+//	A failed request is replied with {"Error": "..."} and status 400 (bad request), 404 (not found) or 405 (wrong method).
+//	Other paths are files of the web explorer.
+
+// httpAddr : Listen address of HTTP API, e.g. "localhost:8080". HTTP API is disabled if it is "". See Config.
+var httpAddr string
+
+// BlockInfo : Reply of /api/tip, /api/blocks, /api/block and /api/search. Height is -1 if the block is in a side branch.
+//			   Block of a node other than Full Node may be header only, if its data is not downloaded.
+//			   Header is the serialized Block Header (80 bytes) in hexadecimal, see Block.Serialize.
+type BlockInfo struct {
+	Hash      string
+	Height    int
+	MainChain bool
+	Header    string
+	Block     *Block
+}
+
+// httpMaxBlocks : Maximum count of /api/blocks
+const httpMaxBlocks = 100
+
+// NewBlockInfo : Return the BlockInfo of a block at height of main chain, -1 if it is in a side branch
+func NewBlockInfo(block *Block, height int) *BlockInfo {
+	header := block.CopyHeader()
+	header.Serialize()
+	return &BlockInfo{
+		Hash:      hex.EncodeToString(block.CurrBlockHash),
+		Height:    height,
+		MainChain: height >= 0,
+		Header:    hex.EncodeToString(header.ByteStream),
+		Block:     block,
+	}
+}
+
+// TxInfo : Reply of /api/tx. Transaction is nil if the data is not a transaction, e.g. text in Genesis Block.
+//			A pending transaction in Mempool is not Confirmed, its BlockHash is "" and Height is -1.
+type TxInfo struct {
+	Hash        string
+	Confirmed   bool
+	BlockHash   string
+	Height      int
+	Index       int
+	Data        []byte
+	Transaction *Transaction
+}
+
+// SubmitResult : Reply of POST /api/tx if the transaction is added to Mempool
+type SubmitResult struct {
+	Hash string
+}
+
+// httpError : Reply of a failed request
+type httpError struct {
+	Error string
+}
+
+// serveHTTP : Start HTTP API on addr. Requests read the chain with state.Snapshot(), same as handleMsg.
+func serveHTTP(addr string, state *ChainState) {
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/tip", func(w http.ResponseWriter, r *http.Request) { httpTip(w, r, state) })
+	mux.HandleFunc("/api/blocks", func(w http.ResponseWriter, r *http.Request) { httpBlocks(w, r, state) })
+	mux.HandleFunc("/api/block/", func(w http.ResponseWriter, r *http.Request) { httpBlock(w, r, state) })
+	mux.HandleFunc("/api/search", func(w http.ResponseWriter, r *http.Request) { httpSearch(w, r, state) })
+	mux.HandleFunc("/api/tree/", func(w http.ResponseWriter, r *http.Request) { httpTree(w, r, state) })
+	mux.HandleFunc("/api/tx", func(w http.ResponseWriter, r *http.Request) { httpTx(w, r, state) })
+	mux.HandleFunc("/api/tx/", func(w http.ResponseWriter, r *http.Request) { httpTx(w, r, state) })
+	mux.HandleFunc("/api/proof", func(w http.ResponseWriter, r *http.Request) { httpProof(w, r, state) })
+	mux.HandleFunc("/api/proof/", func(w http.ResponseWriter, r *http.Request) { httpProof(w, r, state) })
+	mux.HandleFunc("/api/mempool", func(w http.ResponseWriter, r *http.Request) { httpMempool(w, r, state) })
+	mux.HandleFunc("/api/peers", func(w http.ResponseWriter, r *http.Request) { httpPeers(w, r, state) })
+	mux.HandleFunc("/api/", func(w http.ResponseWriter, r *http.Request) {
+		httpFail(w, http.StatusNotFound, "unknown path "+r.URL.Path)
+	})
+	mux.Handle("/", explorerHandler())
+
+	logf(logInfo, "Node:	HTTP API listening on %s\n", addr)
+	err := http.ListenAndServe(addr, mux)
+	logln(logError, "Node:	HTTP API is stopped,", err)
+}
+
+// httpReply : Write v in JSON with status
+func httpReply(w http.ResponseWriter, status int, v interface{}) {
+	bufSend, _ := json.Marshal(v)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(bufSend)
+}
+
+// httpFail : Write a httpError with status
+func httpFail(w http.ResponseWriter, status int, message string) {
+	httpReply(w, status, &httpError{Error: message})
+}
+
+// httpAccept : Log the request and check its method. Reply 405 and return false if the method is not allowed.
+func httpAccept(w http.ResponseWriter, r *http.Request, method string) bool {
+	logf(logDebug, "Node:	<%s> HTTP %s %s\n", r.RemoteAddr, r.Method, r.URL.Path)
+	if r.Method != method {
+		w.Header().Set("Allow", method)
+		httpFail(w, http.StatusMethodNotAllowed, "method "+r.Method+" is not allowed")
+		return false
+	}
+	return true
+}
+
+// httpPathArgs : Split the path after prefix, e.g. ["<hash>", "<leaf>"] of "/api/proof/<hash>/<leaf>"
+func httpPathArgs(r *http.Request, prefix string) []string {
+	rest := strings.Trim(strings.TrimPrefix(r.URL.Path, prefix), "/")
+	if rest == "" {
+		return nil
+	}
+	return strings.Split(rest, "/")
+}
+
+func httpTip(w http.ResponseWriter, r *http.Request, state *ChainState) {
+
+	if !httpAccept(w, r, http.MethodGet) {
+		return
+	}
+	selfNodeChain := state.Snapshot()
+	if len(selfNodeChain.Blocks) == 0 {
+		httpFail(w, http.StatusNotFound, "blockchain is empty")
+		return
+	}
+	height := len(selfNodeChain.Blocks) - 1
+	httpReply(w, http.StatusOK, NewBlockInfo(selfNodeChain.Blocks[height].CopyHeader(), height))
+}
+
+func httpBlocks(w http.ResponseWriter, r *http.Request, state *ChainState) {
+
+	if !httpAccept(w, r, http.MethodGet) {
+		return
+	}
+	count := 10
+	if r.URL.Query().Get("count") != "" {
+		var err error
+		count, err = strconv.Atoi(r.URL.Query().Get("count"))
+		if err != nil || count < 1 || count > httpMaxBlocks {
+			httpFail(w, http.StatusBadRequest, "count must be between 1 and "+strconv.Itoa(httpMaxBlocks))
+			return
+		}
+	}
+	selfNodeChain := state.Snapshot()
+	infos := []*BlockInfo{}
+	for i := len(selfNodeChain.Blocks) - 1; i >= 0 && len(infos) < count; i-- {
+		infos = append(infos, NewBlockInfo(selfNodeChain.Blocks[i].CopyHeader(), i))
+	}
+	httpReply(w, http.StatusOK, infos)
+}
+
+func httpBlock(w http.ResponseWriter, r *http.Request, state *ChainState) {
+
+	if !httpAccept(w, r, http.MethodGet) {
+		return
+	}
+	args := httpPathArgs(r, "/api/block/")
+	if len(args) != 1 {
+		httpFail(w, http.StatusBadRequest, "usage: /api/block/<hash|height>")
+		return
+	}
+	info, status, message := findBlock(args[0], false, state.Snapshot())
+	if info == nil {
+		httpFail(w, status, message)
+		return
+	}
+	httpReply(w, http.StatusOK, info)
+}
+
+func httpSearch(w http.ResponseWriter, r *http.Request, state *ChainState) {
+
+	if !httpAccept(w, r, http.MethodGet) {
+		return
+	}
+	query := strings.TrimSpace(r.URL.Query().Get("q"))
+	if query == "" {
+		httpFail(w, http.StatusBadRequest, "usage: /api/search?q=<height|block hash|Merkle Tree Root|transaction hash>")
+		return
+	}
+	info, status, message := findBlock(query, true, state.Snapshot())
+	if info == nil {
+		httpFail(w, status, message)
+		return
+	}
+	httpReply(w, http.StatusOK, info)
+}
+
+// findBlock : Find a block by id, i.e. a height of main chain, or a CurrBlockHash (main chain or side branch).
+//			   If searchFlag is true, id could also be a Merkle Tree Root or a leaf hash of a data in main chain.
+//			   Return the BlockInfo, or the HTTP status and message if it is not found.
+func findBlock(id string, searchFlag bool, selfNodeChain Blockchain) (*BlockInfo, int, string) {
+
+	// A hash is 64 digits in hexadecimal, otherwise it is a height
+	if len(id) != 64 {
+		height, err := strconv.Atoi(id)
+		if err != nil {
+			return nil, http.StatusBadRequest, "block hash or height is invalid"
+		}
+		if height < 0 || height >= len(selfNodeChain.Blocks) {
+			return nil, http.StatusNotFound, "height is out of range"
+		}
+		return NewBlockInfo(selfNodeChain.Blocks[height], height), 0, ""
+	}
+
+	hash, err := hex.DecodeString(id)
+	if err != nil {
+		return nil, http.StatusBadRequest, "block hash is not in hexadecimal"
+	}
+	for i := 0; i < len(selfNodeChain.Blocks); i++ {
+		if string(selfNodeChain.Blocks[i].CurrBlockHash) == string(hash) {
+			return NewBlockInfo(selfNodeChain.Blocks[i], i), 0, ""
+		}
+	}
+	if searchFlag {
+		for i := 0; i < len(selfNodeChain.Blocks); i++ {
+			if string(selfNodeChain.Blocks[i].Root) == string(hash) {
+				return NewBlockInfo(selfNodeChain.Blocks[i], i), 0, ""
+			}
+		}
+		if block, _ := LoadBlockByLeaf(hash, selfNodeChain.UserID); block != nil {
+			return NewBlockInfo(block, block.Height), 0, ""
+		}
+	}
+	// Not in main chain, search side branches in Local Database
+	if block := LoadBlock(hash, selfNodeChain.UserID); block != nil {
+		return NewBlockInfo(block, block.Height), 0, ""
+	}
+	return nil, http.StatusNotFound, "block is not found"
+}
+
+func httpTx(w http.ResponseWriter, r *http.Request, state *ChainState) {
+
+	// POST /api/tx : Submit a transaction
+	if r.Method == http.MethodPost && len(httpPathArgs(r, "/api/tx")) == 0 {
+		logf(logDebug, "Node:	<%s> HTTP %s %s\n", r.RemoteAddr, r.Method, r.URL.Path)
+		payload, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, mempoolMaxTxSize))
+		if err != nil {
+			httpFail(w, http.StatusBadRequest, "cannot read the transaction, "+err.Error())
+			return
+		}
+		txHash, err := submitTransaction(payload, r.RemoteAddr, state.Snapshot().UserID)
+		if err != nil {
+			httpFail(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		httpReply(w, http.StatusOK, &SubmitResult{Hash: hex.EncodeToString(txHash)})
+		return
+	}
+
+	// GET /api/tx/<hash> : Search main chain using the leaf index, then Mempool
+	if !httpAccept(w, r, http.MethodGet) {
+		return
+	}
+	args := httpPathArgs(r, "/api/tx")
+	if len(args) != 1 {
+		httpFail(w, http.StatusBadRequest, "usage: /api/tx/<hash>")
+		return
+	}
+	txHash, err := hex.DecodeString(args[0])
+	if err != nil {
+		httpFail(w, http.StatusBadRequest, "transaction hash is not in hexadecimal")
+		return
+	}
+
+	selfNodeChain := state.Snapshot()
+	if block, index := LoadBlockByLeaf(txHash, selfNodeChain.UserID); block != nil {
+		info := &TxInfo{Hash: args[0], Confirmed: true, BlockHash: hex.EncodeToString(block.CurrBlockHash), Height: block.Height, Index: index, Data: block.Data[index]}
+		info.Transaction, _ = DeserializeTransaction(block.Data[index])
+		httpReply(w, http.StatusOK, info)
+		return
+	}
+	if data := GetMempool(selfNodeChain.UserID).Get(txHash); data != nil {
+		info := &TxInfo{Hash: args[0], Confirmed: false, Height: -1, Index: -1, Data: data}
+		info.Transaction, _ = DeserializeTransaction(data)
+		httpReply(w, http.StatusOK, info)
+		return
+	}
+	httpFail(w, http.StatusNotFound, "transaction is not found")
+}
+
+func httpProof(w http.ResponseWriter, r *http.Request, state *ChainState) {
+
+	if !httpAccept(w, r, http.MethodGet) {
+		return
+	}
+	var request ProofRequest
+	var n Node
+	var err error
+
+	// Path is [<hash>/]<leaf>, or [<hash>] if data is given
+	args := httpPathArgs(r, "/api/proof")
+	data := r.URL.Query().Get("data")
+	if data != "" {
+		args = append(args, hex.EncodeToString(n.CalSHA256Hash([]byte(data))))
+	}
+	if len(args) != 1 && len(args) != 2 {
+		httpFail(w, http.StatusBadRequest, "usage: /api/proof/[<hash>/]<leaf> or /api/proof[/<hash>]?data=<data>")
+		return
+	}
+	if request.LeafHash, err = hex.DecodeString(args[len(args)-1]); err == nil && len(args) == 2 {
+		request.BlockHash, err = hex.DecodeString(args[0])
+	}
+	if err != nil {
+		httpFail(w, http.StatusBadRequest, "block hash or leaf hash is not in hexadecimal")
+		return
+	}
+
+	// Same as "getLF" if block is not given, otherwise "getPF". Full Node is asked if the data is not found in local Blockchain.
+	var proof *MerkleProof
+	if len(request.BlockHash) == 0 {
+		proof = handleLocate(request.LeafHash, r.RemoteAddr, state.Snapshot())
+	} else {
+		payload, _ := json.Marshal(request)
+		proof = handleProof(payload, r.RemoteAddr, state.Snapshot())
+	}
+	if proof == nil {
+		httpFail(w, http.StatusNotFound, "data is not found")
+		return
+	}
+	httpReply(w, http.StatusOK, proof)
+}
+
+func httpMempool(w http.ResponseWriter, r *http.Request, state *ChainState) {
+
+	if !httpAccept(w, r, http.MethodGet) {
+		return
+	}
+	httpReply(w, http.StatusOK, GetMempool(state.Snapshot().UserID).Entries())
+}
+
+func httpPeers(w http.ResponseWriter, r *http.Request, state *ChainState) {
+
+	if !httpAccept(w, r, http.MethodGet) {
+		return
+	}
+	httpReply(w, http.StatusOK, GetPeerManager(state.Snapshot().UserID).List())
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Mempool : Pending transactions submitted by clients, waiting to be packed in a block by miners.
+//
+//	Transactions are deduplicated by hash. An input could only be spent by one pending transaction (first seen wins).
+//	Pending transactions only spend outputs in UTXO set, i.e. a pending transaction could not spend another one.
+type Mempool struct {
+	mutex     sync.Mutex
+	entries   map[string]*MempoolEntry
+	spentBy   map[string]string
+	totalSize int
+}
+
+// MempoolEntry : A pending transaction
+type MempoolEntry struct {
+	TxHash []byte
+	Data   []byte
+	Fee    uint64
+	Time   int64
+}
+
+// mempoolMaxTxSize : A transaction larger than mempoolMaxTxSize (in byte) is rejected
+const mempoolMaxTxSize = 100 * 1024
+
+// mempoolMaxSize : Total size of pending transactions (in byte). The oldest transactions are evicted when it is full.
+const mempoolMaxSize = 4 * 1024 * 1024
+
+// mempoolMaxCount : Maximum number of pending transactions
+const mempoolMaxCount = 5000
+
+// mempoolExpiry : Pending transactions older than mempoolExpiry (in second) are evicted
+const mempoolExpiry = 24 * 60 * 60
+
+// mempools : Mempool is created once per userID, and shared by all connections of this process
+var mempools = make(map[string]*Mempool)
+var mempoolsMutex sync.Mutex
+
+// GetMempool : Return the Mempool of userID. Create it if it is not created yet.
+func GetMempool(userID string) *Mempool {
+
+	mempoolsMutex.Lock()
+	defer mempoolsMutex.Unlock()
+
+	if mp, ok := mempools[userID]; ok {
+		return mp
+	}
+	mp := &Mempool{
+		entries: make(map[string]*MempoolEntry),
+		spentBy: make(map[string]string),
+	}
+	mempools[userID] = mp
+	return mp
+}
+
+// Add : Validate a transaction and add it to Mempool. Return error if it is rejected.
+func (mp *Mempool) Add(data []byte, utxo *UTXOSet) error {
+
+	// Step 1 : Check the transaction itself
+	if len(data) > mempoolMaxTxSize {
+		return fmt.Errorf("transaction is too large")
+	}
+	tx, err := DeserializeTransaction(data)
+	if err != nil {
+		return fmt.Errorf("data is not a transaction")
+	}
+	if tx.VerifySignature() == false {
+		return fmt.Errorf("transaction is not signed correctly")
+	}
+	fee, err := utxo.CheckTransaction(tx)
+	if err != nil {
+		return err
+	}
+
+	mp.mutex.Lock()
+	defer mp.mutex.Unlock()
+
+	// Step 2 : Check duplication and conflict with other pending transactions
+	txHash := tx.Hash()
+	key := hex.EncodeToString(txHash)
+	if mp.entries[key] != nil {
+		return fmt.Errorf("transaction is already in mempool")
+	}
+	for i := 0; i < len(tx.Inputs); i++ {
+		if _, ok := mp.spentBy[utxoKey(tx.Inputs[i].PrevTxHash, tx.Inputs[i].OutIndex)]; ok {
+			return fmt.Errorf("transaction conflicts with a pending transaction")
+		}
+	}
+
+	// Step 3 : Evict expired transactions, then the oldest ones until there is space
+	mp.evict(len(data))
+
+	mp.entries[key] = &MempoolEntry{TxHash: txHash, Data: data, Fee: fee, Time: time.Now().Unix()}
+	for i := 0; i < len(tx.Inputs); i++ {
+		mp.spentBy[utxoKey(tx.Inputs[i].PrevTxHash, tx.Inputs[i].OutIndex)] = key
+	}
+	mp.totalSize = mp.totalSize + len(data)
+	return nil
+}
+
+// evict : Remove expired transactions, then remove the oldest transactions until newSize bytes could be added
+func (mp *Mempool) evict(newSize int) {
+	now := time.Now().Unix()
+	for key, entry := range mp.entries {
+		if now-entry.Time > mempoolExpiry {
+			mp.remove(key)
+		}
+	}
+	for len(mp.entries) > 0 && (len(mp.entries)+1 > mempoolMaxCount || mp.totalSize+newSize > mempoolMaxSize) {
+		oldest := mp.sortedEntries()[0]
+		mp.remove(hex.EncodeToString(oldest.TxHash))
+	}
+}
+
+// remove : Remove a pending transaction by its key
+func (mp *Mempool) remove(key string) {
+	entry := mp.entries[key]
+	if entry == nil {
+		return
+	}
+	if tx, err := DeserializeTransaction(entry.Data); err == nil {
+		for i := 0; i < len(tx.Inputs); i++ {
+			delete(mp.spentBy, utxoKey(tx.Inputs[i].PrevTxHash, tx.Inputs[i].OutIndex))
+		}
+	}
+	mp.totalSize = mp.totalSize - len(entry.Data)
+	delete(mp.entries, key)
+}
+
+// sortedEntries : Pending transactions, the oldest first
+func (mp *Mempool) sortedEntries() []*MempoolEntry {
+	entries := make([]*MempoolEntry, 0, len(mp.entries))
+	for _, entry := range mp.entries {
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Time != entries[j].Time {
+			return entries[i].Time < entries[j].Time
+		}
+		return string(entries[i].TxHash) < string(entries[j].TxHash)
+	})
+	return entries
+}
+
+// Entries : Return all pending transactions, the oldest first
+func (mp *Mempool) Entries() []*MempoolEntry {
+	mp.mutex.Lock()
+	defer mp.mutex.Unlock()
+
+	return mp.sortedEntries()
+}
+
+// Get : Return the data of a pending transaction by its hash. Return nil if it is not in Mempool.
+func (mp *Mempool) Get(txHash []byte) []byte {
+	mp.mutex.Lock()
+	defer mp.mutex.Unlock()
+
+	entry := mp.entries[hex.EncodeToString(txHash)]
+	if entry == nil {
+		return nil
+	}
+	return entry.Data
+}
+
+// RemoveBlock : Remove transactions packed in a block, and pending transactions which spend the same inputs.
+func (mp *Mempool) RemoveBlock(bk *Block) {
+	mp.mutex.Lock()
+	defer mp.mutex.Unlock()
+
+	for i := 1; i < len(bk.Data); i++ {
+		tx, err := DeserializeTransaction(bk.Data[i])
+		if err != nil {
+			continue
+		}
+		mp.remove(hex.EncodeToString(tx.Hash()))
+		for j := 0; j < len(tx.Inputs); j++ {
+			if key, ok := mp.spentBy[utxoKey(tx.Inputs[j].PrevTxHash, tx.Inputs[j].OutIndex)]; ok {
+				mp.remove(key)
+			}
+		}
+	}
+}
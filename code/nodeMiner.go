@@ -1,15 +1,19 @@
 package main
 
 import (
-	"bytes"
+	"crypto/ecdsa"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"net"
+	"strconv"
 	"strings"
+	"time"
 )
 
 // minerSendMsg : Just send message to a node. Get reply.
-func minerSendMsg(conn net.Conn, msg []byte) (reply []byte) {
-	_, err := conn.Write(msg)
+func minerSendMsg(conn net.Conn, command string, payload []byte) (reply []byte) {
+	err := writeMsg(conn, command, payload)
 	if err != nil {
 		fmt.Println("Miner:	Error writing:")
 		fmt.Println("Miner:	", err)
@@ -18,8 +22,7 @@ func minerSendMsg(conn net.Conn, msg []byte) (reply []byte) {
 
 	fmt.Println("Miner:	...sending message to nearby node")
 
-	buf := make([]byte, 8192)
-	_, err = conn.Read(buf)
+	_, reply, err = readMsg(conn)
 	if err != nil {
 		fmt.Println("Miner:	...Error Reading:")
 		fmt.Println("Miner:	...", err)
@@ -27,17 +30,133 @@ func minerSendMsg(conn net.Conn, msg []byte) (reply []byte) {
 	}
 
 	fmt.Println("Miner:	...received message from nearby node")
-	return bytes.TrimRight(buf, "\x00")
+	return reply
 }
 
-// minerGetDataFromUI : Receive data, in format of string, from user.
-func minerGetDataFromUI() [][]byte {
+// minerGetDataFromUI : Receive transactions, in format of "recipient:amount", from user.
+//						Recipient is an address in hexadecimal. Transactions are signed by the wallet of userID.
+//						Inputs are unspent outputs of the wallet, queried from the node. The change is returned to the wallet.
+func minerGetDataFromUI(userID string, serverAddr *net.TCPAddr) [][]byte {
 	var dataRaw string
-	var dataString []string
-	fmt.Println("Miner:	Enter data to be packed in blockchain (seperated by ',')")
+
+	wallet := LoadWallet(userID)
+	if wallet == nil {
+		return nil
+	}
+	address := CalAddress(&wallet.PublicKey)
+	unspent := minerGetUTXO(serverAddr, address)
+	var balance uint64
+	for i := 0; i < len(unspent); i++ {
+		balance = balance + unspent[i].Output.Amount
+	}
+	fmt.Printf("Miner:	Your address is %x, balance is %d\n", address, balance)
+	fmt.Println("Miner:	Enter transactions to be packed in blockchain, in format of recipient:amount[:fee] (seperated by ',')")
 	fmt.Scan(&dataRaw)
-	dataString = strings.Split(dataRaw, ",")
-	return arrayConvertorStringToBytes(dataString)
+	return minerCreateTransactions(wallet, unspent, strings.Split(dataRaw, ","))
+}
+
+// minerGetDataFromArgs : Same as minerGetDataFromUI, but transactions are given in dataRaw, e.g. by "--data" of command line.
+func minerGetDataFromArgs(userID string, serverAddr *net.TCPAddr, dataRaw string) [][]byte {
+
+	wallet := LoadWallet(userID)
+	if wallet == nil {
+		return nil
+	}
+	unspent := minerGetUTXO(serverAddr, CalAddress(&wallet.PublicKey))
+	return minerCreateTransactions(wallet, unspent, strings.Split(dataRaw, ","))
+}
+
+// minerCreateTransactions : Sign transactions in format of "recipient:amount[:fee]" with wallet. Invalid ones are skipped.
+//							 Inputs are taken from unspent outputs of the wallet, the change is returned to the wallet.
+func minerCreateTransactions(wallet *ecdsa.PrivateKey, unspent []*UTXOEntry, dataString []string) [][]byte {
+	var data [][]byte
+
+	address := CalAddress(&wallet.PublicKey)
+	for i := 0; i < len(dataString); i++ {
+		field := strings.Split(dataString[i], ":")
+		if len(field) != 2 && len(field) != 3 {
+			fmt.Println("Miner:	Skip invalid transaction", dataString[i])
+			continue
+		}
+		recipient, err := hex.DecodeString(field[0])
+		amount, err2 := strconv.ParseUint(field[1], 10, 64)
+		if err != nil || err2 != nil {
+			fmt.Println("Miner:	Skip invalid transaction", dataString[i])
+			continue
+		}
+		// Fee is optional. Higher fee per byte is packed first by the node.
+		var fee uint64
+		if len(field) == 3 {
+			fee, err = strconv.ParseUint(field[2], 10, 64)
+			if err != nil {
+				fmt.Println("Miner:	Skip invalid transaction", dataString[i])
+				continue
+			}
+		}
+
+		// Spend unspent outputs until amount + fee is reached. Each output is spent once only.
+		var inputs []*TxInput
+		var inputSum uint64
+		for len(unspent) > 0 && inputSum < amount+fee {
+			inputs = append(inputs, &TxInput{PrevTxHash: unspent[0].TxHash, OutIndex: unspent[0].Index})
+			inputSum = inputSum + unspent[0].Output.Amount
+			unspent = unspent[1:]
+		}
+		if inputSum < amount+fee {
+			fmt.Println("Miner:	Skip transaction, balance is not enough,", dataString[i])
+			continue
+		}
+		outputs := []*TxOutput{{Recipient: recipient, Amount: amount}}
+		if inputSum > amount+fee {
+			outputs = append(outputs, &TxOutput{Recipient: address, Amount: inputSum - amount - fee})
+		}
+
+		tx, err := CreateTransaction(wallet, inputs, outputs, fee)
+		if err != nil {
+			fmt.Println("Miner:	Cannot sign transaction,", err)
+			continue
+		}
+		data = append(data, tx.Serialize())
+	}
+	return data
+}
+
+// minerGetUTXO : Ask the node for unspent outputs owned by an address
+func minerGetUTXO(serverAddr *net.TCPAddr, address []byte) []*UTXOEntry {
+	var unspent []*UTXOEntry
+
+	conn, err := net.DialTimeout("tcp", serverAddr.String(), peerDialTimeout)
+	if err != nil {
+		fmt.Println("Miner:	Cannot connect to node,", err)
+		return unspent
+	}
+	conn.SetDeadline(time.Now().Add(peerTimeout))
+	if writeMsg(conn, "getUT", address) == nil {
+		if _, reply, err := readMsg(conn); err == nil {
+			json.Unmarshal(reply, &unspent)
+		}
+	}
+	conn.Close()
+	return unspent
+}
+
+// minerGetMempool : Ask the node for pending transactions in Mempool
+func minerGetMempool(serverAddr *net.TCPAddr) []*MempoolEntry {
+	var entries []*MempoolEntry
+
+	conn, err := net.DialTimeout("tcp", serverAddr.String(), peerDialTimeout)
+	if err != nil {
+		fmt.Println("Miner:	Cannot connect to node,", err)
+		return entries
+	}
+	conn.SetDeadline(time.Now().Add(peerTimeout))
+	if writeMsg(conn, "getMP", nil) == nil {
+		if _, reply, err := readMsg(conn); err == nil {
+			json.Unmarshal(reply, &entries)
+		}
+	}
+	conn.Close()
+	return entries
 }
 
 // minerPrintBlock : Print a block in command line interface.
@@ -46,12 +165,48 @@ func minerPrintBlock(block *Block) {
 	fmt.Printf("	> Timestamp	: %010d\n", block.Timestamp)
 	fmt.Printf("	> PrevBlockHash	: %x\n", block.PrevBlockHash)
 	fmt.Printf("	> Root		: %x\n", block.Root)
+	fmt.Printf("	> Bits		: %08x\n", block.Bits)
 	fmt.Printf("	> Nonce		: %010d\n", block.Nonce)
 	fmt.Printf("	> CurrBlockHash	: %x\n", block.CurrBlockHash)
 	fmt.Printf("	> Data		: %s\n", block.Data)
+	fmt.Printf("	> ExtraNonce	: %010d\n", block.ExtraNonce)
 	fmt.Printf("      	Header in Byte Stream (80 bytes, equals to 160 digits in hex)\n")
-	fmt.Printf("	[Magic#        ][TS    ][PrevBlockHash                                                 ][Root                                                          ][Nonce ]\n")
+	fmt.Printf("	[Magic#][TS    ][PrevBlockHash                                                 ][Root                                                          ][Bits  ][Nonce ]\n")
 	fmt.Printf("	%x\n\n", block.ByteStream)
 
 	return
 }
+
+// minerWatchTip : Ask the node for its tip every second. Close cancel if the tip is no longer prevBlockHash.
+//				   Stop watching when done is closed.
+func minerWatchTip(serverAddr *net.TCPAddr, prevBlockHash []byte, cancel chan struct{}, done chan struct{}) {
+	ticker := time.NewTicker(1 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+
+		conn, err := net.DialTimeout("tcp", serverAddr.String(), peerDialTimeout)
+		if err != nil {
+			continue
+		}
+		conn.SetDeadline(time.Now().Add(peerTimeout))
+		var tipChain Blockchain
+		if writeMsg(conn, "getTP", nil) == nil {
+			if _, reply, err := readMsg(conn); err == nil {
+				json.Unmarshal(reply, &tipChain)
+			}
+		}
+		conn.Close()
+
+		if len(tipChain.Blocks) > 0 && string(tipChain.Blocks[0].CurrBlockHash) != string(prevBlockHash) {
+			fmt.Println("Miner:	New tip arrives at node.")
+			close(cancel)
+			return
+		}
+	}
+}
@@ -0,0 +1,524 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Peer : Another node known by this node, identified by its listening address "host:port"
+type Peer struct {
+	Addr string
+	// Seed is a peer given at start, e.g. Full Node. Seed is never removed, only skipped for a while if it is not reachable.
+	Seed bool
+	// Unix time of the last successful exchange and the last failure
+	LastSeen    int64
+	LastFailure int64
+	Failures    int
+	// Verified is true after a hello exchange shows that a node listens at Addr on the host of the connection.
+	// Only then Addr is trusted as From of "inv" and "addr". It is checked again after restart.
+	Verified bool `json:"-"`
+}
+
+// PeerScore : Score of misbehavior of a peer, e.g. sending invalid blocks. Peer is identified by its listening address "host:port".
+//
+//	The address is either dialed by this node, or verified From of a message (see VerifyPeer), so that a peer on another host could not blame others.
+//	Scores are not kept by host, since many nodes may run on the same host, e.g. 127.0.0.1. A peer misbehaving does not affect the others.
+//	Peer is banned until BannedUntil when Score reaches peerBanScore.
+type PeerScore struct {
+	Addr        string
+	Score       int
+	BannedUntil int64
+}
+
+// addressBook : Content of the address book file
+type addressBook struct {
+	Peers  []*Peer
+	Scores []*PeerScore
+}
+
+// PeerManager : Peers of a node. Every node listens and dials, i.e. a node is both server and client of its peers.
+//
+//	New blocks and transactions are announced to all peers with "inv". A peer requests unknown items with "getdt".
+//	Since every node keeps the chain and relays it, the network keeps working if Full Node goes away.
+//	Peers are found with "getaddr" / "addr", and saved in the address book <dataDir>/peers_<userID>.json.
+type PeerManager struct {
+	mutex    sync.Mutex
+	self     string
+	peers    map[string]*Peer
+	scores   map[string]*PeerScore
+	bookPath string
+}
+
+// InvMessage : Payload of "inv" and "getdt". From is the listening address of the sender, so that the receiver could dial it back.
+//				From is written by the sender, it is trusted only after it is verified (see VerifyPeer).
+type InvMessage struct {
+	From string
+	// Type is "block" or "tx". Hashes are CurrBlockHash of blocks, or hash of transactions.
+	Type   string
+	Hashes [][]byte
+}
+
+// AddrMessage : Payload of "addr". A node relays addresses of new peers, so that every node could find each other.
+type AddrMessage struct {
+	From  string
+	Addrs []string
+}
+
+// peerMaxCount : Maximum number of peers. New peers are ignored when it is full.
+const peerMaxCount = 32
+
+// peerMaxFailures : A peer (except seed) is removed after peerMaxFailures failures in a row
+const peerMaxFailures = 3
+
+// peerRetryInterval : A peer is skipped for peerRetryInterval (in second) after a failure
+const peerRetryInterval = 30
+
+// peerDialTimeout : Timeout of connecting to a peer
+const peerDialTimeout = 3 * time.Second
+
+// peerTimeout : Timeout of a whole request to a peer, i.e. sending the request and receiving the reply
+const peerTimeout = 30 * time.Second
+
+// peerMaxAddrs : Maximum number of addresses handled in one "addr" or "getaddr" reply
+const peerMaxAddrs = 100
+
+// peerBanScore : A peer is banned when its misbehavior score reaches peerBanScore, e.g. sending a block which fails ValidateBlock
+const peerBanScore = 100
+
+// peerBanDuration : Duration of a ban (in second)
+const peerBanDuration = 24 * 60 * 60
+
+// seedFile : List of seed peers, one "host:port" per line. Lines starting with "#" are comments.
+//
+//	Default is ./seeds.txt, could be changed by environment variable SEED_FILE.
+var seedFile = envString("SEED_FILE", "./seeds.txt")
+
+// peerManagers : PeerManager is created once per userID, and shared by all connections of this process
+var peerManagers = make(map[string]*PeerManager)
+var peerManagersMutex sync.Mutex
+
+// GetPeerManager : Return the PeerManager of userID. Create it if it is not created yet.
+func GetPeerManager(userID string) *PeerManager {
+
+	peerManagersMutex.Lock()
+	defer peerManagersMutex.Unlock()
+
+	if pm, ok := peerManagers[userID]; ok {
+		return pm
+	}
+	pm := &PeerManager{peers: make(map[string]*Peer), scores: make(map[string]*PeerScore), bookPath: dataPath("peers_" + userID + ".json")}
+	pm.loadBook()
+	peerManagers[userID] = pm
+	return pm
+}
+
+// loadBook : Load peers and their scores from the address book. Bans are kept after restart.
+//			  Address book of previous version, i.e. an array of peers, is read too.
+func (pm *PeerManager) loadBook() {
+	bookBytes, err := ioutil.ReadFile(pm.bookPath)
+	if err != nil {
+		return
+	}
+	var book addressBook
+	if err = json.Unmarshal(bookBytes, &book); err != nil {
+		err = json.Unmarshal(bookBytes, &book.Peers)
+	}
+	if err != nil {
+		logln(logError, "Node:	Cannot read address book,", err)
+		return
+	}
+	for i := 0; i < len(book.Peers) && len(pm.peers) < peerMaxCount; i++ {
+		if book.Peers[i] != nil && normalizePeerAddr(book.Peers[i].Addr) == book.Peers[i].Addr {
+			book.Peers[i].Seed = false
+			pm.peers[book.Peers[i].Addr] = book.Peers[i]
+		}
+	}
+	for i := 0; i < len(book.Scores); i++ {
+		if book.Scores[i] != nil && normalizePeerAddr(book.Scores[i].Addr) == book.Scores[i].Addr {
+			pm.scores[book.Scores[i].Addr] = book.Scores[i]
+		}
+	}
+}
+
+// saveBook : Save peers to the address book. Caller must hold the mutex.
+func (pm *PeerManager) saveBook() {
+	book := addressBook{Peers: make([]*Peer, 0, len(pm.peers)), Scores: make([]*PeerScore, 0, len(pm.scores))}
+	for _, peer := range pm.peers {
+		book.Peers = append(book.Peers, peer)
+	}
+	for _, score := range pm.scores {
+		book.Scores = append(book.Scores, score)
+	}
+	sort.Slice(book.Peers, func(i, j int) bool { return book.Peers[i].Addr < book.Peers[j].Addr })
+	sort.Slice(book.Scores, func(i, j int) bool { return book.Scores[i].Addr < book.Scores[j].Addr })
+	bookBytes, _ := json.MarshalIndent(&book, "", "	")
+
+	// Write a temporary file then rename it, so that the address book is never half written
+	os.MkdirAll(filepath.Dir(pm.bookPath), os.ModePerm)
+	if err := ioutil.WriteFile(pm.bookPath+".tmp", bookBytes, 0644); err != nil {
+		logln(logError, "Node:	Cannot save address book,", err)
+		return
+	}
+	os.Rename(pm.bookPath+".tmp", pm.bookPath)
+}
+
+// LoadSeeds : Add peers listed in the seed file. Missing seed file is ignored.
+func (pm *PeerManager) LoadSeeds(path string) {
+	file, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		pm.AddSeed(line)
+	}
+}
+
+// normalizePeerAddr : Resolve "host:port" so that the same node always has the same key, e.g. "localhost:9999" is "127.0.0.1:9999"
+func normalizePeerAddr(addr string) string {
+	tcpAddr, err := net.ResolveTCPAddr("tcp", addr)
+	if err != nil || tcpAddr.Port == 0 {
+		return ""
+	}
+	return tcpAddr.String()
+}
+
+// peerHost : Host of "host:port", i.e. the IP address. A host without port is returned as is.
+func peerHost(addr string) string {
+	if normalized := normalizePeerAddr(addr); normalized != "" {
+		addr = normalized
+	}
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return host
+}
+
+// SetSelf : Set the listening address of this node. It is sent to peers, and never added as a peer.
+func (pm *PeerManager) SetSelf(addr string) {
+	pm.mutex.Lock()
+	defer pm.mutex.Unlock()
+
+	pm.self = normalizePeerAddr(addr)
+	delete(pm.peers, pm.self)
+}
+
+// Self : Listening address of this node
+func (pm *PeerManager) Self() string {
+	pm.mutex.Lock()
+	defer pm.mutex.Unlock()
+
+	return pm.self
+}
+
+// AddSeed : Add a peer given at start. Seed is never removed.
+func (pm *PeerManager) AddSeed(addr string) {
+	pm.AddPeer(addr)
+
+	pm.mutex.Lock()
+	defer pm.mutex.Unlock()
+	if peer, ok := pm.peers[normalizePeerAddr(addr)]; ok {
+		peer.Seed = true
+	}
+}
+
+// AddPeer : Add a peer. Return true if it is a new peer, i.e. it is valid, not self, not known yet, and the list is not full.
+func (pm *PeerManager) AddPeer(addr string) bool {
+	pm.mutex.Lock()
+	defer pm.mutex.Unlock()
+
+	addr = normalizePeerAddr(addr)
+	if addr == "" || addr == pm.self {
+		return false
+	}
+	if _, ok := pm.peers[addr]; ok {
+		return false
+	}
+	if len(pm.peers) >= peerMaxCount {
+		return false
+	}
+	pm.peers[addr] = &Peer{Addr: addr}
+	pm.saveBook()
+	logf(logInfo, "Node:	New peer %s\n", addr)
+	return true
+}
+
+// IsBanned : Check if the peer listening at addr is banned
+func (pm *PeerManager) IsBanned(addr string) bool {
+	pm.mutex.Lock()
+	defer pm.mutex.Unlock()
+
+	return pm.isBanned(normalizePeerAddr(addr), time.Now().Unix())
+}
+
+// isBanned : Check if the peer listening at addr is banned at now. Caller must hold the mutex.
+func (pm *PeerManager) isBanned(addr string, now int64) bool {
+	score := pm.scores[addr]
+	return score != nil && score.BannedUntil > now
+}
+
+// Misbehave : Increase the misbehavior score of the peer listening at addr, i.e. a peer dialed by this node, or verified From of a message.
+//			   The peer is banned for peerBanDuration when the score reaches peerBanScore. Banned peer is not dialed, and its requests are rejected.
+//			   Other peers on the same host are not affected.
+func (pm *PeerManager) Misbehave(addr string, score int, reason string) {
+	pm.mutex.Lock()
+	defer pm.mutex.Unlock()
+
+	addr = normalizePeerAddr(addr)
+	if addr == "" {
+		return
+	}
+	peerScore := pm.scores[addr]
+	if peerScore == nil {
+		peerScore = &PeerScore{Addr: addr}
+		pm.scores[addr] = peerScore
+	}
+	peerScore.Score = peerScore.Score + score
+	logf(logError, "Node:	Peer %s misbehaves, %s. Score is %d\n", addr, reason, peerScore.Score)
+	if peerScore.Score >= peerBanScore {
+		peerScore.Score = 0
+		peerScore.BannedUntil = time.Now().Unix() + peerBanDuration
+		logf(logError, "Node:	Peer %s is banned until %s\n", addr, time.Unix(peerScore.BannedUntil, 0).Format(time.RFC3339))
+	}
+	pm.saveBook()
+}
+
+// VerifyPeer :	Check the listening address addr written by the sender of a connection from remote.
+//				addr must be on the host of the connection, and the node listening at addr must tell addr as its own address in the reply of "getaddr".
+//				Verified address is added as a peer, and trusted afterwards. Return false if addr could not be verified.
+func (pm *PeerManager) VerifyPeer(addr string, remote string) bool {
+
+	addr = normalizePeerAddr(addr)
+	if addr == "" || addr == pm.Self() || peerHost(addr) != peerHost(remote) {
+		return false
+	}
+	pm.mutex.Lock()
+	peer := pm.peers[addr]
+	verified := peer != nil && peer.Verified
+	pm.mutex.Unlock()
+	if verified {
+		return true
+	}
+
+	reply, err := pm.SendMsg(addr, "getaddr", nil)
+	var addrs []string
+	if err != nil || json.Unmarshal(reply, &addrs) != nil {
+		return false
+	}
+	for i := 0; i < len(addrs); i++ {
+		if addrs[i] == addr {
+			pm.AddPeer(addr)
+			pm.markVerified(addr)
+			return true
+		}
+	}
+	return false
+}
+
+// markVerified : Record that a node listens at the address of a peer, see Peer.Verified
+func (pm *PeerManager) markVerified(addr string) {
+	pm.mutex.Lock()
+	defer pm.mutex.Unlock()
+
+	if peer, ok := pm.peers[addr]; ok {
+		peer.Verified = true
+	}
+}
+
+// Peers : Return reachable peers, seeds first, then the most recently seen first.
+//		   Peers failed within peerRetryInterval and banned peers are skipped.
+func (pm *PeerManager) Peers() []string {
+	pm.mutex.Lock()
+	defer pm.mutex.Unlock()
+
+	now := time.Now().Unix()
+	var peers []*Peer
+	for _, peer := range pm.peers {
+		if peer.Failures > 0 && now-peer.LastFailure < peerRetryInterval {
+			continue
+		}
+		if pm.isBanned(peer.Addr, now) {
+			continue
+		}
+		peers = append(peers, peer)
+	}
+	sort.Slice(peers, func(i, j int) bool {
+		if peers[i].Seed != peers[j].Seed {
+			return peers[i].Seed
+		}
+		if peers[i].LastSeen != peers[j].LastSeen {
+			return peers[i].LastSeen > peers[j].LastSeen
+		}
+		return peers[i].Addr < peers[j].Addr
+	})
+
+	addrs := make([]string, len(peers))
+	for i := 0; i < len(peers); i++ {
+		addrs[i] = peers[i].Addr
+	}
+	return addrs
+}
+
+// markResult : Record the result of an exchange with a peer. Peer (except seed) is removed after peerMaxFailures failures in a row.
+func (pm *PeerManager) markResult(addr string, success bool) {
+	pm.mutex.Lock()
+	defer pm.mutex.Unlock()
+
+	peer := pm.peers[addr]
+	if peer == nil {
+		return
+	}
+	if success {
+		peer.LastSeen = time.Now().Unix()
+		peer.Failures = 0
+		return
+	}
+	peer.LastFailure = time.Now().Unix()
+	peer.Failures++
+	if !peer.Seed && peer.Failures >= peerMaxFailures {
+		delete(pm.peers, addr)
+		pm.saveBook()
+		logf(logError, "Node:	Peer %s is removed, not reachable\n", addr)
+	}
+}
+
+// Addrs : Return addresses of peers which are not failing or banned, and the address of this node. Reply of "getaddr".
+func (pm *PeerManager) Addrs() []string {
+	pm.mutex.Lock()
+	defer pm.mutex.Unlock()
+
+	now := time.Now().Unix()
+	addrs := []string{}
+	if pm.self != "" {
+		addrs = append(addrs, pm.self)
+	}
+	for _, peer := range pm.peers {
+		if len(addrs) >= peerMaxAddrs {
+			break
+		}
+		if peer.Failures == 0 && !pm.isBanned(peer.Addr, now) {
+			addrs = append(addrs, peer.Addr)
+		}
+	}
+	sort.Strings(addrs)
+	return addrs
+}
+
+// List : Return a copy of all known peers, including failing and banned ones, sorted by address
+func (pm *PeerManager) List() []Peer {
+	pm.mutex.Lock()
+	defer pm.mutex.Unlock()
+
+	peers := []Peer{}
+	for _, peer := range pm.peers {
+		peers = append(peers, *peer)
+	}
+	sort.Slice(peers, func(i, j int) bool {
+		return peers[i].Addr < peers[j].Addr
+	})
+	return peers
+}
+
+// AddAddrs : Add addresses received from a peer. Return the new ones.
+func (pm *PeerManager) AddAddrs(addrs []string) []string {
+	var newAddrs []string
+	for i := 0; i < len(addrs) && i < peerMaxAddrs; i++ {
+		if pm.AddPeer(addrs[i]) {
+			newAddrs = append(newAddrs, normalizePeerAddr(addrs[i]))
+		}
+	}
+	return newAddrs
+}
+
+// SendMsg : Send a request to a peer and return its reply. One connection per request.
+func (pm *PeerManager) SendMsg(addr string, command string, payload []byte) ([]byte, error) {
+
+	conn, err := net.DialTimeout("tcp", addr, peerDialTimeout)
+	if err != nil {
+		pm.markResult(addr, false)
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(peerTimeout))
+
+	err = writeMsg(conn, command, payload)
+	var reply []byte
+	if err == nil {
+		_, reply, err = readMsg(conn)
+	}
+	pm.markResult(addr, err == nil)
+	return reply, err
+}
+
+// Discover : Ask every peer for addresses of other peers with "getaddr"
+func (pm *PeerManager) Discover() {
+	for _, addr := range pm.Peers() {
+		reply, err := pm.SendMsg(addr, "getaddr", nil)
+		if err != nil {
+			continue
+		}
+		var addrs []string
+		if json.Unmarshal(reply, &addrs) != nil {
+			pm.Misbehave(addr, 10, "invalid addresses")
+			continue
+		}
+		newAddrs := pm.AddAddrs(addrs)
+		if len(newAddrs) > 0 {
+			logf(logInfo, "Node:	%d new peer(s) from %s\n", len(newAddrs), addr)
+		}
+	}
+}
+
+// Hello :	Find peers, then tell every peer the listening address of this node, so that they relay new blocks and transactions to it.
+//			The peer replies its own listening address. If it is the dialed address, the peer is verified (see Peer.Verified).
+func (pm *PeerManager) Hello() {
+	pm.Discover()
+	self := []byte(pm.Self())
+	for _, addr := range pm.Peers() {
+		reply, err := pm.SendMsg(addr, "hello", self)
+		if err != nil {
+			logf(logError, "Node:	Peer %s is not reachable\n", addr)
+		} else if normalizePeerAddr(string(reply)) == addr {
+			pm.markVerified(addr)
+		}
+	}
+}
+
+// Announce : Send "inv" of new blocks or transactions to every peer except the one it comes from. Peers request unknown items.
+func (pm *PeerManager) Announce(invType string, hashes [][]byte, except string) {
+	inv := &InvMessage{From: pm.Self(), Type: invType, Hashes: hashes}
+	invJSON, _ := json.Marshal(inv)
+	for _, addr := range pm.Peers() {
+		if addr == except {
+			continue
+		}
+		go pm.SendMsg(addr, "inv", invJSON)
+	}
+}
+
+// AnnounceAddrs : Send "addr" of new peers to every peer except the one it comes from
+func (pm *PeerManager) AnnounceAddrs(addrs []string, except string) {
+	msg := &AddrMessage{From: pm.Self(), Addrs: addrs}
+	msgJSON, _ := json.Marshal(msg)
+	for _, addr := range pm.Peers() {
+		if addr == except {
+			continue
+		}
+		go pm.SendMsg(addr, "addr", msgJSON)
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+// testPeerManager : A PeerManager whose address book is in a temporary directory
+func testPeerManager(t *testing.T, bookPath string) *PeerManager {
+	pm := &PeerManager{peers: make(map[string]*Peer), scores: make(map[string]*PeerScore), bookPath: bookPath}
+	pm.loadBook()
+	return pm
+}
+
+func TestMisbehaveSameHost(t *testing.T) {
+
+	bookPath := filepath.Join(t.TempDir(), "peers.json")
+	pm := testPeerManager(t, bookPath)
+	pm.AddPeer("127.0.0.1:7001")
+	pm.AddPeer("127.0.0.1:7002")
+
+	// Only the peer at 7001 misbehaves
+	pm.Misbehave("127.0.0.1:7001", peerBanScore/2, "invalid data")
+	if pm.IsBanned("127.0.0.1:7001") {
+		t.Fatalf("peer is banned before its score reaches peerBanScore")
+	}
+	pm.Misbehave("localhost:7001", peerBanScore/2, "invalid block")
+
+	for _, loaded := range []*PeerManager{pm, testPeerManager(t, bookPath)} {
+		if !loaded.IsBanned("127.0.0.1:7001") {
+			t.Errorf("misbehaving peer is not banned")
+		}
+		if loaded.IsBanned("127.0.0.1:7002") {
+			t.Errorf("another peer on the same host is banned")
+		}
+	}
+	if peers := pm.Peers(); len(peers) != 1 || peers[0] != "127.0.0.1:7002" {
+		t.Errorf("Peers returns %v, expected only the peer at 7002", peers)
+	}
+
+	// Misbehave never adds a peer
+	pm.Misbehave("127.0.0.1:7003", 1, "invalid addresses")
+	if len(pm.List()) != 2 {
+		t.Errorf("Misbehave adds a peer, %d peers are known", len(pm.List()))
+	}
+}
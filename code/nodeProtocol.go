@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// Message : Every exchange between nodes and miners is framed as a message
+//
+//	Message is defined as
+//	4	bytes:	MagicNumber		(8-digit hexadecimal integer, 4B61726C)
+//	12	bytes:	Command			(ASCII, padded with 0x00, e.g. "addBK")
+//	4	bytes:	Length			(10-digit decimal positive integer, length of Payload)
+//	4	bytes:	Checksum		(first 4 bytes of sha256(sha256(Payload)))
+//	Variable :	Payload
+//
+// Length of Message Header = 24 bytes
+const msgHeaderLen = 24
+const msgCommandLen = 12
+
+// msgMaxPayload : Upper bound of a payload. Reject anything larger to avoid allocating unbounded memory.
+const msgMaxPayload = 32 * 1024 * 1024
+
+var msgMagicNumber = []byte{0x4B, 0x61, 0x72, 0x6C}
+
+var errMsgMagic = errors.New("invalid magic number")
+var errMsgCommand = errors.New("invalid command")
+var errMsgLength = errors.New("payload is too large")
+var errMsgChecksum = errors.New("checksum mismatch")
+
+// msgChecksum : Calculate the checksum of a payload
+func msgChecksum(payload []byte) []byte {
+	first := sha256.Sum256(payload)
+	second := sha256.Sum256(first[:])
+	return second[0:4]
+}
+
+// writeMsg : Frame the payload with a message header, and write the whole message to w.
+func writeMsg(w io.Writer, command string, payload []byte) error {
+
+	if len(command) == 0 || len(command) > msgCommandLen {
+		return errMsgCommand
+	}
+	if len(payload) > msgMaxPayload {
+		return errMsgLength
+	}
+
+	// Convert everythings in header to []byte
+	byteCommand := make([]byte, msgCommandLen)
+	copy(byteCommand, command)
+
+	byteLength := make([]byte, 4)
+	binary.BigEndian.PutUint32(byteLength, uint32(len(payload)))
+
+	// Generate the message, and write it with a single call
+	message := bytes.Join(
+		[][]byte{
+			msgMagicNumber,
+			byteCommand,
+			byteLength,
+			msgChecksum(payload),
+			payload,
+		},
+		[]byte{},
+	)
+	_, err := w.Write(message)
+	return err
+}
+
+// readMsg : Read exactly one message from r. Verify its header and checksum before returning the payload.
+func readMsg(r io.Reader) (command string, payload []byte, err error) {
+
+	// Step 1 : Read the fixed length header
+	header := make([]byte, msgHeaderLen)
+	if _, err = io.ReadFull(r, header); err != nil {
+		return "", nil, err
+	}
+	if !bytes.Equal(header[0:4], msgMagicNumber) {
+		return "", nil, errMsgMagic
+	}
+	command = string(bytes.TrimRight(header[4:16], "\x00"))
+	if len(command) == 0 {
+		return "", nil, errMsgCommand
+	}
+	length := binary.BigEndian.Uint32(header[16:20])
+	if length > msgMaxPayload {
+		return "", nil, errMsgLength
+	}
+
+	// Step 2 : Read the payload, then verify its checksum
+	payload = make([]byte, length)
+	if _, err = io.ReadFull(r, payload); err != nil {
+		return "", nil, err
+	}
+	if !bytes.Equal(header[20:24], msgChecksum(payload)) {
+		return "", nil, errMsgChecksum
+	}
+
+	return command, payload, nil
+}
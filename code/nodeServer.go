@@ -1,157 +1,568 @@
 package main
 
 import (
-	"bytes"
 	"encoding/json"
 	"fmt"
-	"io"
 	"net"
+	"strconv"
+	"time"
 )
 
-func handleMsg(conn net.Conn, selfNodeChain Blockchain) {
+func handleMsg(conn net.Conn, state *ChainState) {
 
-	fmt.Printf("Node:	<%s> Connection established \n", conn.RemoteAddr().String())
+	logf(logDebug, "Node:	<%s> Connection established \n", conn.RemoteAddr().String())
 
-	// Receive Message, maximum length is 8kB
-	bufReceive := make([]byte, 8192)
-	bufSend := make([]byte, 8192)
-	_, err := conn.Read(bufReceive)
-	if err == io.EOF {
-		fmt.Println("Node:	Error reading:")
-		fmt.Println("Node:	", err)
-	}
+	// Blockchain in memory, shared by all connections. Changes are made through state.Update().
+	selfNodeChain := state.Snapshot()
+
+	// Receive Message. Message is framed by readMsg(), so its length is not limited by a buffer
+	request, payload, err := readMsg(conn)
 	if err != nil {
-		fmt.Println("Node:	Error reading:")
-		fmt.Println("Node:	", err)
+		logln(logError, "Node:	Error reading:")
+		logln(logError, "Node:	", err)
+		conn.Close()
+		return
 	}
 
-	// Choose action depending on message header
-	request := string(bytes.TrimRight(bufReceive, "\x00"))[0:5]
-	payload := bytes.TrimRight(bufReceive, "\x00")[5:]
-
 	if request == "addBK" {
 		// "addBK":
-		//	1. Return prevBlock to miner by conn.Write()
-		fmt.Printf("Node:	<%s> Miner would like to add a block to blockchain\n", conn.RemoteAddr().String())
-		selfNodeChain.LoadFromDB(selfNodeChain.UserID)
-		bufSend = selfNodeChain.Blocks[len(selfNodeChain.Blocks)-1].CurrBlockHash
-		fmt.Printf("Node:	<%s> Return PrevBlockHash to Miner\n", conn.RemoteAddr().String())
-		if bufSend != nil {
-			_, err = conn.Write(bufSend)
-		}
-
-		//	2. Receive newBlock from miner by conn.Read()
-		fmt.Printf("Node:	<%s> Waiting for new block\n", conn.RemoteAddr().String())
-		_, err = conn.Read(bufReceive)
+		//	1. Receive newBlock from miner. Miner builds it using the template of "getTM".
+		logf(logDebug, "Node:	<%s> Miner would like to add a block to blockchain\n", conn.RemoteAddr().String())
 		var newBlock *Block
-		err = json.Unmarshal(bytes.TrimRight(bufReceive, "\x00")[5:], &newBlock)
-
-		//	3. Add the block to blockchain. Update Blockchain before adding
-		selfNodeChain.LoadFromDB(selfNodeChain.UserID)
-		failFlag := false
-		if newBlock.ValidateBlock() == false {
-			failFlag = true
+		var addErr error
+		if json.Unmarshal(payload, &newBlock) != nil || newBlock == nil {
+			addErr = rejectBlock(RejectMalformed, "cannot read the block")
 		} else {
-			failFlag = !selfNodeChain.AddBlock(newBlock)
+			//	2. Add the block to blockchain. Announce it to peers if it is added.
+			addErr = handleBlock(newBlock, "", conn, state)
+			if addErr == nil {
+				GetPeerManager(selfNodeChain.UserID).Announce("block", [][]byte{newBlock.CurrBlockHash}, "")
+			}
 		}
 
-		//  4. Return result to Miner. Need to LoadFromDB to update the block in RAM, to see if new block is added.
-		selfNodeChain.LoadFromDB(selfNodeChain.UserID)
-		if failFlag == false {
-			bufSend = []byte("Success - Blockchain is updated.")
-			_, err = conn.Write(bufSend)
-			fmt.Println("Node:	Blockchain now:")
+		//  3. Return BlockResult to Miner. Need a new snapshot to see if new block is the tip.
+		selfNodeChain = state.Snapshot()
+		result := NewBlockResult(addErr)
+		if addErr == nil && string(selfNodeChain.Blocks[len(selfNodeChain.Blocks)-1].CurrBlockHash) != string(newBlock.CurrBlockHash) {
+			result = &BlockResult{Status: BlockStaleTip, Detail: "Someone is faster than you. Block is kept in a side branch."}
+		} else if addErr == nil && logLevel >= logDebug {
+			logln(logDebug, "Node:	Blockchain now:")
 			selfNodeChain.PrintChain()
+		}
+		bufSend, _ := json.Marshal(result)
+		err = writeMsg(conn, request, bufSend)
+
+	} else if request == "hello" {
+
+		// "hello": A peer tells its listening address. New blocks and transactions are relayed to it.
+		//			The address is verified first (see VerifyPeer), then relayed to other peers with "addr".
+		logf(logInfo, "Node:	<%s> Peer %s says hello\n", conn.RemoteAddr().String(), payload)
+		pm := GetPeerManager(selfNodeChain.UserID)
+		addr := normalizePeerAddr(string(payload))
+		if pm.IsBanned(addr) {
+			err = writeMsg(conn, request, []byte("Fail    - Peer is banned."))
+		} else if !pm.VerifyPeer(addr, conn.RemoteAddr().String()) {
+			err = writeMsg(conn, request, []byte("Fail    - Listening address is not verified."))
 		} else {
-			bufSend = []byte("Fail    - Someone is faster then you.")
-			_, err = conn.Write(bufSend)
+			pm.AnnounceAddrs([]string{addr}, addr)
+			err = writeMsg(conn, request, []byte(pm.Self()))
 		}
 
+	} else if request == "getaddr" {
+
+		// "getaddr": Return addresses of known peers, so that the peer could find other nodes.
+		logf(logDebug, "Node:	<%s> Peer would like to retrive addresses of peers\n", conn.RemoteAddr().String())
+		bufSend, _ := json.Marshal(GetPeerManager(selfNodeChain.UserID).Addrs())
+		err = writeMsg(conn, request, bufSend)
+
+	} else if request == "addr" {
+
+		// "addr": A peer relays addresses of new peers. New ones are relayed to other peers.
+		bufSend := handleAddr(payload, conn, selfNodeChain)
+		err = writeMsg(conn, request, bufSend)
+
+	} else if request == "inv" {
+
+		// "inv": A peer announces new blocks or transactions. Unknown items are requested from it with "getdt".
+		bufSend := handleAnnounce(payload, conn, state)
+		err = writeMsg(conn, request, bufSend)
+
+	} else if request == "getheaders" {
+
+		// "getheaders": Return headers of main chain after the latest common block in the locator of the peer.
+		//				 Peer downloads only new headers, instead of the whole chain of "getBC".
+		bufSend := handleHeaders(payload, conn, selfNodeChain)
+		err = writeMsg(conn, request, bufSend)
+
+	} else if request == "getdt" {
+
+		// "getdt": A peer requests full blocks or transactions announced by this node.
+		bufSend := handleGetData(payload, conn, selfNodeChain)
+		err = writeMsg(conn, request, bufSend)
+
+	} else if request == "getTM" {
+
+		// "getTM": Return a BlockTemplate. Payload is the address to receive the reward.
+		//			Node chooses the content of the block, miner only calculates Proof of Work.
+		logf(logDebug, "Node:	<%s> Miner would like to get a block template\n", conn.RemoteAddr().String())
+		tmpl, tmplErr := selfNodeChain.CreateTemplate(payload)
+		if tmplErr != nil {
+			logf(logError, "Node:	<%s> Cannot create block template, %s\n", conn.RemoteAddr().String(), tmplErr)
+		}
+		bufSend, _ := json.Marshal(tmpl)
+		err = writeMsg(conn, request, bufSend)
+		logf(logDebug, "Node:	<%s> Return block template to Miner\n", conn.RemoteAddr().String())
+
+	} else if request == "getPF" {
+
+		// "getPF": Return a Merkle branch if User provides a block hash and a data (or its leaf hash).
+		//			Client can verify the data is in the block, without downloading the whole Block.Data
+		proof := handleProof(payload, conn.RemoteAddr().String(), selfNodeChain)
+		bufSend, _ := json.Marshal(proof)
+		err = writeMsg(conn, request, bufSend)
+		logf(logDebug, "Node:	<%s> Return Merkle Proof to client.\n", conn.RemoteAddr().String())
+
+	} else if request == "getLF" {
+
+		// "getLF": Return a Merkle branch of a data if User provides its leaf hash only, i.e. the block is found by the leaf index.
+		//			Header of the block containing the data is in the reply, so client does not need to know the block first.
+		proof := handleLocate(payload, conn.RemoteAddr().String(), selfNodeChain)
+		bufSend, _ := json.Marshal(proof)
+		err = writeMsg(conn, request, bufSend)
+		logf(logDebug, "Node:	<%s> Return Merkle Proof to client.\n", conn.RemoteAddr().String())
+
+	} else if request == "subTX" {
+
+		// "subTX": Client submits a transaction to Mempool. Miners pack pending transactions in new blocks.
+		bufSend := handleSubmit(payload, conn.RemoteAddr().String(), selfNodeChain)
+		err = writeMsg(conn, request, bufSend)
+
+	} else if request == "getMP" {
+
+		// "getMP": Return all pending transactions in Mempool, the oldest first.
+		logf(logDebug, "Node:	<%s> Client would like to retrive pending transactions\n", conn.RemoteAddr().String())
+		bufSend, _ := json.Marshal(GetMempool(selfNodeChain.UserID).Entries())
+		err = writeMsg(conn, request, bufSend)
+		logf(logDebug, "Node:	<%s> Return pending transactions to client.\n", conn.RemoteAddr().String())
+
+	} else if request == "getUT" {
+
+		// "getUT": Return unspent outputs owned by an address. Miner uses them as inputs of new transactions.
+		unspent := handleUTXO(payload, conn, selfNodeChain)
+		bufSend, _ := json.Marshal(unspent)
+		err = writeMsg(conn, request, bufSend)
+		logf(logDebug, "Node:	<%s> Return unspent outputs to client.\n", conn.RemoteAddr().String())
+
 	} else {
 
-		// Other request : getBC or getBK or getTX
+		// Other request : getTP or getBC or getBK or getTX or getBH or getBR
+		//
+		//	getTP:		Return the tip block header.
 		//
 		//	getBC:		Return full block hash.
 		//				i.e. getblocks() in Project Specification
 		//
+		//	getBH:		Return the block at a height of main chain. Payload is the height in decimal, e.g. "12".
+		//
+		//	getBR:		Return headers / blocks of main chain in a range of heights. Payload is a RangeRequest.
+		//
 		//	getBK/	:	Return a block header / data if User provides an ID.
 		//	getTX		In our case,
 		//					ID is Merkle Tree Root for getTX;
 		//					ID is CurrBlockHash for getBK;
 		//				i.e. getdata() in Project Specification, handle with payload.Type = "block" payload.Type = "tx" in ppt slide.
 		result := handleInv(request, payload, conn, selfNodeChain)
-		bufSend, _ = json.Marshal(result)
-		_, err = conn.Write(bufSend)
-		fmt.Printf("Node:	<%s> Return information to client.\n", conn.RemoteAddr().String())
+		bufSend, _ := json.Marshal(result)
+		err = writeMsg(conn, request, bufSend)
+		logf(logDebug, "Node:	<%s> Return information to client.\n", conn.RemoteAddr().String())
+
+	}
 
+	if err != nil {
+		logln(logError, "Node:	Error writing:")
+		logln(logError, "Node:	", err)
 	}
 
 	conn.Close()
-	fmt.Printf("Node:	<%s> Connection is closed\n", conn.RemoteAddr().String())
+	logf(logDebug, "Node:	<%s> Connection is closed\n", conn.RemoteAddr().String())
+
+}
+
+// BlockStatus : What happened to a block sent with "addBK".
+//
+//	accepted	: Block is the new tip of main chain.
+//	stale-tip	: Block is valid, but not on top of the tip, e.g. someone is faster. It is kept in a side branch.
+//	error		: Block could not be checked, e.g. Full Node does not reply.
+//	Otherwise	: Block is rejected, and Status is the RejectReason, e.g. duplicate, bad-pow, bad-merkle, malformed.
+type BlockStatus string
+
+// Status of a block which is not rejected
+const (
+	BlockAccepted BlockStatus = "accepted"
+	BlockStaleTip BlockStatus = "stale-tip"
+	BlockFailed   BlockStatus = "error"
+)
+
+// BlockResult : Reply of "addBK" in JSON. Miners and tools react by Status, Detail is a message for users.
+type BlockResult struct {
+	Status BlockStatus
+	Detail string
+}
+
+// NewBlockResult : Return the BlockResult of the error returned by handleBlock. nil means the block is the new tip.
+func NewBlockResult(err error) *BlockResult {
+	if err == nil {
+		return &BlockResult{Status: BlockAccepted, Detail: "Blockchain is updated."}
+	}
+	if blockErr, ok := err.(*BlockError); ok {
+		return &BlockResult{Status: BlockStatus(blockErr.Reason), Detail: blockErr.Detail}
+	}
+	return &BlockResult{Status: BlockFailed, Detail: err.Error()}
+}
+
+// Stored : Check if the block is stored, i.e. accepted as the new tip or kept in a side branch
+func (result *BlockResult) Stored() bool {
+	return result.Status == BlockAccepted || result.Status == BlockStaleTip
+}
+
+// Err : Return the BlockResult of a rejected block as a BlockError
+func (result *BlockResult) Err() error {
+	return &BlockError{Reason: RejectReason(result.Status), Detail: result.Detail}
+}
+
+// handleBlock :	Validate a new block and add it to blockchain. Return nil if it is added, otherwise the BlockError.
+//					The first data must be a coinbase of the new height, which pays at most subsidy + fees (checked in UTXO set).
+//					Block mined by a miner (from is "") is sent to Full Node first.
+//					Block relayed by peer from (a verified address) is added to Local Database only. The peer is banned if the block is invalid.
+func handleBlock(newBlock *Block, from string, conn net.Conn, state *ChainState) error {
+
+	if err := newBlock.CheckBlock(); err != nil {
+		logf(logError, "Node:	<%s> Invalid block %x, %s\n", conn.RemoteAddr().String(), newBlock.CurrBlockHash, err)
+		if from != "" {
+			GetPeerManager(state.Snapshot().UserID).Misbehave(from, peerBanScore, "invalid block")
+		}
+		return err
+	}
+
+	// If a peer relays a block whose parent is unknown, this node is behind. Synchronize with peers first.
+	// Bits is checked before, so that such blocks are not cheap to make. Synchronization runs outside the writer goroutine (see Sync).
+	if selfNodeChain := state.Snapshot(); from != "" && LoadBlock(newBlock.PrevBlockHash, selfNodeChain.UserID) == nil {
+		if err := selfNodeChain.CheckUnknownParent(newBlock); err != nil {
+			logf(logError, "Node:	<%s> Invalid block %x, %s\n", conn.RemoteAddr().String(), newBlock.CurrBlockHash, err)
+			return err
+		}
+		logf(logInfo, "Node:	<%s> Parent of block %x is unknown, synchronize with peers\n", conn.RemoteAddr().String(), newBlock.CurrBlockHash)
+		state.Sync()
+	}
+
+	// Blocks are added one by one in the writer goroutine of ChainState
+	var addErr error
+	state.Update(func(selfNodeChain *Blockchain) {
+
+		// Block is known already. Header only block is not a duplicate, its data is stored by AcceptBlock.
+		if knownBlock := LoadBlock(newBlock.CurrBlockHash, selfNodeChain.UserID); knownBlock != nil && len(knownBlock.Data) > 0 {
+			logf(logInfo, "Node:	<%s> Block %x is already known\n", conn.RemoteAddr().String(), newBlock.CurrBlockHash)
+			addErr = rejectBlock(RejectDuplicate, "block %x is already known", newBlock.CurrBlockHash)
+			return
+		}
+
+		// Parent may be still unknown after synchronization, e.g. peers are behind the sender. It is not a misbehavior.
+		if err := selfNodeChain.ValidateCoinbaseHeight(newBlock); err != nil {
+			logf(logError, "Node:	<%s> Invalid block %x, %s\n", conn.RemoteAddr().String(), newBlock.CurrBlockHash, err)
+			if blockErr, ok := err.(*BlockError); from != "" && (!ok || blockErr.Reason != RejectBadPrev) {
+				GetPeerManager(selfNodeChain.UserID).Misbehave(from, 20, "invalid block, "+err.Error())
+			}
+			addErr = err
+			return
+		}
+		if from != "" {
+			addErr = selfNodeChain.AcceptBlock(newBlock)
+		} else {
+			addErr = selfNodeChain.AddBlock(newBlock)
+		}
+
+		// Transactions and reward are checked when the block is applied to UTXO set, so that it is not applied twice
+		if blockErr, ok := addErr.(*BlockError); from != "" && ok && (blockErr.Reason == RejectBadTx || blockErr.Reason == RejectBadCoinbase) {
+			GetPeerManager(selfNodeChain.UserID).Misbehave(from, 20, "invalid block, "+addErr.Error())
+		}
+	})
+	return addErr
+}
+
+// handleAnnounce :	Request unknown blocks or transactions announced by a peer, add them, and relay them to other peers.
+//					Block stored as header only is requested too, to get its data. From must be verified first, since items are requested from it.
+func handleAnnounce(payload []byte, conn net.Conn, state *ChainState) []byte {
+
+	selfNodeChain := state.Snapshot()
+
+	var inv InvMessage
+	if json.Unmarshal(payload, &inv) != nil || normalizePeerAddr(inv.From) == "" {
+		logf(logError, "Node:	<%s> Invalid announcement\n", conn.RemoteAddr().String())
+		return []byte("Fail    - Invalid announcement.")
+	}
+	pm := GetPeerManager(selfNodeChain.UserID)
+	from := normalizePeerAddr(inv.From)
+	if pm.IsBanned(from) {
+		logf(logError, "Node:	<%s> Peer %s is banned\n", conn.RemoteAddr().String(), from)
+		return []byte("Fail    - Peer is banned.")
+	}
+	if !pm.VerifyPeer(from, conn.RemoteAddr().String()) {
+		logf(logError, "Node:	<%s> Cannot verify peer %s\n", conn.RemoteAddr().String(), from)
+		return []byte("Fail    - Listening address is not verified.")
+	}
+
+	// Step 1 : Find unknown items
+	var unknown [][]byte
+	for i := 0; i < len(inv.Hashes); i++ {
+		if inv.Type == "block" {
+			if knownBlock := LoadBlock(inv.Hashes[i], selfNodeChain.UserID); knownBlock == nil || len(knownBlock.Data) == 0 {
+				unknown = append(unknown, inv.Hashes[i])
+			}
+		} else if inv.Type == "tx" && GetMempool(selfNodeChain.UserID).Get(inv.Hashes[i]) == nil {
+			unknown = append(unknown, inv.Hashes[i])
+		}
+	}
+	if len(unknown) == 0 {
+		return []byte("Success - Nothing new.")
+	}
+	logf(logInfo, "Node:	<%s> Peer %s announces %d new %s(s)\n", conn.RemoteAddr().String(), from, len(unknown), inv.Type)
+
+	// Step 2 : Request them with "getdt"
+	request, _ := json.Marshal(&InvMessage{From: pm.Self(), Type: inv.Type, Hashes: unknown})
+	reply, err := pm.SendMsg(from, "getdt", request)
+	if err != nil {
+		logf(logError, "Node:	<%s> Cannot get data from peer %s\n", conn.RemoteAddr().String(), from)
+		return []byte("Fail    - Cannot get data.")
+	}
+
+	// Step 3 : Add them, then relay the new ones to other peers
+	var added [][]byte
+	if inv.Type == "block" {
+		var blocks []*Block
+		if json.Unmarshal(reply, &blocks) != nil {
+			pm.Misbehave(from, 20, "invalid data")
+		}
+		for i := 0; i < len(blocks); i++ {
+			if blocks[i] != nil && handleBlock(blocks[i], from, conn, state) == nil {
+				added = append(added, blocks[i].CurrBlockHash)
+			}
+		}
+	} else {
+		var txs [][]byte
+		json.Unmarshal(reply, &txs)
+		for i := 0; i < len(txs); i++ {
+			tx, err := DeserializeTransaction(txs[i])
+			if err != nil || tx.VerifySignature() == false {
+				pm.Misbehave(from, 20, "invalid transaction")
+				continue
+			}
+			if err = GetMempool(selfNodeChain.UserID).Add(txs[i], GetUTXOSet(selfNodeChain.UserID)); err != nil {
+				logf(logError, "Node:	<%s> Transaction is rejected, %s\n", conn.RemoteAddr().String(), err)
+				continue
+			}
+			added = append(added, tx.Hash())
+		}
+	}
+	if len(added) > 0 {
+		pm.Announce(inv.Type, added, from)
+	}
+	return []byte(fmt.Sprintf("Success - %d %s(s) added.", len(added), inv.Type))
+}
+
+// handleHeaders :	Return at most maxHeadersPerMsg headers after the first block in locator which is in main chain.
+//					Return headers from Genesis Block if no block in locator is known.
+func handleHeaders(payload []byte, conn net.Conn, selfNodeChain Blockchain) []byte {
+
+	var request HeadersRequest
+	json.Unmarshal(payload, &request)
+
+	start := FindLocator(selfNodeChain.Blocks, request.Locator) + 1
+	headers := []*Block{}
+	for i := start; i < len(selfNodeChain.Blocks) && len(headers) < maxHeadersPerMsg; i++ {
+		headers = append(headers, selfNodeChain.Blocks[i].CopyHeader())
+	}
+	logf(logInfo, "Node:	<%s> Return %d header(s) from height %d\n", conn.RemoteAddr().String(), len(headers), start)
+	bufSend, _ := json.Marshal(headers)
+	return bufSend
+}
 
+// handleAddr :	Add addresses relayed by a peer, then relay the new ones to other peers
+func handleAddr(payload []byte, conn net.Conn, selfNodeChain Blockchain) []byte {
+
+	var msg AddrMessage
+	pm := GetPeerManager(selfNodeChain.UserID)
+	if json.Unmarshal(payload, &msg) != nil || pm.IsBanned(msg.From) || !pm.VerifyPeer(msg.From, conn.RemoteAddr().String()) {
+		return []byte("Fail    - Invalid addresses.")
+	}
+	newAddrs := pm.AddAddrs(msg.Addrs)
+	if len(newAddrs) > 0 {
+		logf(logInfo, "Node:	<%s> %d new peer(s) from %s\n", conn.RemoteAddr().String(), len(newAddrs), msg.From)
+		pm.AnnounceAddrs(newAddrs, normalizePeerAddr(msg.From))
+	}
+	return []byte(fmt.Sprintf("Success - %d new address(es).", len(newAddrs)))
+}
+
+// handleGetData :	Return full blocks or pending transactions requested by a peer. Unknown items are skipped.
+func handleGetData(payload []byte, conn net.Conn, selfNodeChain Blockchain) []byte {
+
+	var inv InvMessage
+	json.Unmarshal(payload, &inv)
+	if GetPeerManager(selfNodeChain.UserID).IsBanned(inv.From) {
+		logf(logError, "Node:	<%s> Peer %s is banned\n", conn.RemoteAddr().String(), inv.From)
+		return []byte("[]")
+	}
+	logf(logDebug, "Node:	<%s> Peer requests %d %s(s)\n", conn.RemoteAddr().String(), len(inv.Hashes), inv.Type)
+
+	if inv.Type == "block" {
+		blocks := []*Block{}
+		for i := 0; i < len(inv.Hashes); i++ {
+			if block := LoadBlock(inv.Hashes[i], selfNodeChain.UserID); block != nil && len(block.Data) > 0 {
+				blocks = append(blocks, block)
+			}
+		}
+		bufSend, _ := json.Marshal(blocks)
+		return bufSend
+	}
+	txs := [][]byte{}
+	for i := 0; i < len(inv.Hashes); i++ {
+		if data := GetMempool(selfNodeChain.UserID).Get(inv.Hashes[i]); data != nil {
+			txs = append(txs, data)
+		}
+	}
+	bufSend, _ := json.Marshal(txs)
+	return bufSend
+}
+
+// RangeRequest : Payload of "getBR". Blocks of main chain at heights From..To (both included) are returned, Genesis Block is height 0.
+//				  Only headers are returned if HeaderOnly is true, otherwise full blocks (header only if this node does not have the data).
+//				  The reply is cut at maxHeadersPerMsg headers or maxBlocksPerMsg blocks, then requester asks again from the next height.
+type RangeRequest struct {
+	From       int
+	To         int
+	HeaderOnly bool
+}
+
+// maxBlocksPerMsg : Maximum number of full blocks in a "getBR" reply, so that the reply is within msgMaxPayload
+const maxBlocksPerMsg = 16
+
+// Blocks : Return the blocks of the range in blocks, i.e. main chain, and their heights. To is limited by the tip.
+func (request *RangeRequest) Blocks(blocks []*Block) ([]*Block, []int) {
+	limit := maxBlocksPerMsg
+	if request.HeaderOnly {
+		limit = maxHeadersPerMsg
+	}
+	start := request.From
+	if start < 0 {
+		start = 0
+	}
+	result := []*Block{}
+	heights := []int{}
+	for i := start; i <= request.To && i < len(blocks) && len(result) < limit; i++ {
+		if request.HeaderOnly {
+			result = append(result, blocks[i].CopyHeader())
+		} else {
+			result = append(result, blocks[i])
+		}
+		heights = append(heights, i)
+	}
+	return result, heights
+}
+
+// BlockReply : Reply of "getTP", "getBC", "getBK", "getBH", "getBR" and "getTX". It is a Blockchain with the height of every block,
+//				since Block.Height is not sent. Heights[i] is the height of Blocks[i] in main chain of the node.
+type BlockReply struct {
+	Blockchain
+	Heights []int
 }
 
-func handleInv(request string, payload []byte, conn net.Conn, selfNodeChain Blockchain) Blockchain {
+// add : Append a block at height to the reply
+func (reply *BlockReply) add(block *Block, height int) {
+	reply.Blocks = append(reply.Blocks, block)
+	reply.Heights = append(reply.Heights, height)
+}
 
-	var resultChain Blockchain
+// SetHeights : Set Block.Height of every block using Heights, -1 if the node does not report it
+func (reply *BlockReply) SetHeights() {
+	for i := 0; i < len(reply.Blocks); i++ {
+		reply.Blocks[i].Height = -1
+		if i < len(reply.Heights) {
+			reply.Blocks[i].Height = reply.Heights[i]
+		}
+	}
+}
 
-	if request == "getBC" {
+func handleInv(request string, payload []byte, conn net.Conn, selfNodeChain Blockchain) BlockReply {
+
+	var resultChain BlockReply
+
+	if request == "getTP" {
+		// If "getTP" is detected, return a blockchain with a single block == tip of the blockchain. Only return block header.
+		// Miner uses it to check if a new tip arrives while mining.
+		resultChain.UserID = selfNodeChain.UserID
+		if len(selfNodeChain.Blocks) > 0 {
+			resultChain.add(selfNodeChain.Blocks[len(selfNodeChain.Blocks)-1].CopyHeader(), len(selfNodeChain.Blocks)-1)
+		}
+	} else if request == "getBC" {
 		// If "getBC" is detected, return a blockchain with headers only
-		// Remark: In LoadFromDB(), a node downloads all block headers from Full Node. Local Blockchain is already the newest.
-		fmt.Printf("Node:	<%s> Client would like to retrive all block hashes\n", conn.RemoteAddr().String())
-		selfNodeChain.LoadFromDB(selfNodeChain.UserID)
+		// Remark: Only return Local Blockchain. Local Blockchain is kept up to date by announcements of peers.
+		logf(logDebug, "Node:	<%s> Client would like to retrive all block hashes\n", conn.RemoteAddr().String())
 		resultChain.UserID = selfNodeChain.UserID
 		for i := 0; i < len(selfNodeChain.Blocks); i++ {
-			resultChain.Blocks = append(resultChain.Blocks, &Block{
-				Timestamp:     selfNodeChain.Blocks[i].Timestamp,
-				PrevBlockHash: selfNodeChain.Blocks[i].PrevBlockHash,
-				Root:          selfNodeChain.Blocks[i].Root,
-				Nonce:         selfNodeChain.Blocks[i].Nonce,
-				CurrBlockHash: selfNodeChain.Blocks[i].CurrBlockHash,
-			})
+			resultChain.add(selfNodeChain.Blocks[i].CopyHeader(), i)
 		}
 	} else if request == "getBK" {
 		// If "getBK" is detected, return a blockchain with a single block == target block. Only return block header.
-		// Remark: In LoadFromDB(), a node downloads all block headers from Full Node. Local Blockchain is already the newest.
-		fmt.Printf("Node:	<%s> Client would like to check if a block exists\n", conn.RemoteAddr().String())
-		fmt.Printf("Node:	<%s> The Block Hash is %x\n", conn.RemoteAddr().String(), payload)
-		selfNodeChain.LoadFromDB(selfNodeChain.UserID)
+		// Remark: Local Blockchain is kept up to date by announcements of peers.
+		logf(logDebug, "Node:	<%s> Client would like to check if a block exists\n", conn.RemoteAddr().String())
+		logf(logDebug, "Node:	<%s> The Block Hash is %x\n", conn.RemoteAddr().String(), payload)
 		resultChain.UserID = selfNodeChain.UserID
 		for i := 0; i < len(selfNodeChain.Blocks); i++ {
 			if string(selfNodeChain.Blocks[i].CurrBlockHash) == string(payload) {
-				resultChain.Blocks = append(resultChain.Blocks, &Block{
-					Timestamp:     selfNodeChain.Blocks[i].Timestamp,
-					PrevBlockHash: selfNodeChain.Blocks[i].PrevBlockHash,
-					Root:          selfNodeChain.Blocks[i].Root,
-					Nonce:         selfNodeChain.Blocks[i].Nonce,
-					CurrBlockHash: selfNodeChain.Blocks[i].CurrBlockHash,
-				})
+				resultChain.add(selfNodeChain.Blocks[i].CopyHeader(), i)
 				if len(resultChain.Blocks) > 0 {
-					fmt.Printf("Node:	<%s> Target Block is found\n", conn.RemoteAddr().String())
+					logf(logDebug, "Node:	<%s> Target Block is found\n", conn.RemoteAddr().String())
 					break
 				}
 			}
 		}
 
+	} else if request == "getBH" {
+		// If "getBH" is detected, return a blockchain with a single block == block at the height. It is a full block if this node has its data.
+		logf(logDebug, "Node:	<%s> Client would like to retrive the block at height %s\n", conn.RemoteAddr().String(), payload)
+		resultChain.UserID = selfNodeChain.UserID
+		height, err := strconv.Atoi(string(payload))
+		if err == nil && height >= 0 && height < len(selfNodeChain.Blocks) {
+			resultChain.add(selfNodeChain.Blocks[height], height)
+		}
+
+	} else if request == "getBR" {
+		// If "getBR" is detected, return a blockchain with blocks at heights From..To. See RangeRequest.
+		var rangeRequest RangeRequest
+		json.Unmarshal(payload, &rangeRequest)
+		logf(logDebug, "Node:	<%s> Client would like to retrive blocks at height %d..%d\n", conn.RemoteAddr().String(), rangeRequest.From, rangeRequest.To)
+		resultChain.UserID = selfNodeChain.UserID
+		resultChain.Blocks, resultChain.Heights = rangeRequest.Blocks(selfNodeChain.Blocks)
+
 	} else if request == "getTX" {
 		// If "getTX" is detected, return a blockchain with a single block == target block. It should be a full block with data.
-		fmt.Printf("Node:	<%s> Client would like to check if a data exists\n", conn.RemoteAddr().String())
-		fmt.Printf("Node:	<%s> The Merkle Tree Root is %x\n", conn.RemoteAddr().String(), payload)
-		selfNodeChain.LoadFromDB(selfNodeChain.UserID)
+		logf(logDebug, "Node:	<%s> Client would like to check if a data exists\n", conn.RemoteAddr().String())
+		logf(logDebug, "Node:	<%s> The Merkle Tree Root is %x\n", conn.RemoteAddr().String(), payload)
 		resultChain.UserID = selfNodeChain.UserID
 		// Search in Local Blockchain for (1) Merkle Tree Exist & (2) Local Blockchain has its data. Return target block if both are true.
 		for i := 0; i < len(selfNodeChain.Blocks); i++ {
 			if string(selfNodeChain.Blocks[i].Root) == string(payload) && len(selfNodeChain.Blocks[i].Data) > 0 {
-				resultChain.Blocks = append(resultChain.Blocks, &Block{
+				resultChain.add(&Block{
 					Timestamp:     selfNodeChain.Blocks[i].Timestamp,
 					PrevBlockHash: selfNodeChain.Blocks[i].PrevBlockHash,
 					Root:          selfNodeChain.Blocks[i].Root,
+					Bits:          selfNodeChain.Blocks[i].Bits,
 					Nonce:         selfNodeChain.Blocks[i].Nonce,
 					CurrBlockHash: selfNodeChain.Blocks[i].CurrBlockHash,
 					Data:          selfNodeChain.Blocks[i].Data,
-				})
+					ExtraNonce:    selfNodeChain.Blocks[i].ExtraNonce,
+				}, i)
 				if len(resultChain.Blocks) > 0 {
-					fmt.Printf("Node:	<%s> Target Block is found in local Blockchain\n", conn.RemoteAddr().String())
+					logf(logDebug, "Node:	<%s> Target Block is found in local Blockchain\n", conn.RemoteAddr().String())
 					break
 				}
 			}
@@ -159,29 +570,165 @@ func handleInv(request string, payload []byte, conn net.Conn, selfNodeChain Bloc
 		// Search in Full Node in case it is not found in local blockchain. Return target block if full node has the data.
 		if selfNodeChain.UserID != fullNodePort && len(resultChain.Blocks) == 0 {
 
-			fullNodeAddr, _ := net.ResolveTCPAddr("tcp", fullNodeHost+":"+fullNodePort)
-			fullNodeConn, _ := net.DialTCP("tcp", nil, fullNodeAddr)
-			fmt.Printf("Node:	<%s> Target Block is not found in local Blockchain, now search in Full Node\n", conn.RemoteAddr().String())
+			fullNodeConn, err := net.DialTimeout("tcp", fullNodeHost+":"+fullNodePort, peerDialTimeout)
+			logf(logDebug, "Node:	<%s> Target Block is not found in local Blockchain, now search in Full Node\n", conn.RemoteAddr().String())
 
 			// Step 1:	Send "getTX" to Full Node
-			message := bytes.Join([][]byte{[]byte("getTX"), payload}, []byte{})
-			_, _ = fullNodeConn.Write(message)
-
 			// Step 2:	Receive the block if it is in Full Node.
-			buf := make([]byte, 8192)
-			_, _ = fullNodeConn.Read(buf)
-			fullNodeConn.Close()
+			if err == nil {
+				fullNodeConn.SetDeadline(time.Now().Add(peerTimeout))
+				if writeMsg(fullNodeConn, "getTX", payload) == nil {
+					if _, buf, err := readMsg(fullNodeConn); err == nil {
+						_ = json.Unmarshal(buf, &resultChain)
+					}
+				}
+				fullNodeConn.Close()
+			}
 
-			_ = json.Unmarshal(bytes.TrimRight(buf, "\x00"), &resultChain)
 			if len(resultChain.Blocks) > 0 {
-				fmt.Printf("Node:	<%s> Target Block is found in Full Node Blockchain\n", conn.RemoteAddr().String())
+				logf(logDebug, "Node:	<%s> Target Block is found in Full Node Blockchain\n", conn.RemoteAddr().String())
 			}
 		}
 	}
 
 	//Return a empty blockchain if nothing is found.
 	if len(resultChain.Blocks) == 0 {
-		fmt.Printf("Node:	<%s> No result\n", conn.RemoteAddr().String())
+		logf(logDebug, "Node:	<%s> No result\n", conn.RemoteAddr().String())
 	}
 	return resultChain
 }
+
+func handleProof(payload []byte, client string, selfNodeChain Blockchain) *MerkleProof {
+
+	var request ProofRequest
+	var proof *MerkleProof
+	var n Node
+
+	err := json.Unmarshal(payload, &request)
+	if err != nil {
+		logf(logError, "Node:	<%s> Invalid Merkle Proof request\n", client)
+		return nil
+	}
+	if len(request.LeafHash) == 0 {
+		request.LeafHash = n.CalSHA256Hash(request.Data)
+	}
+	logf(logDebug, "Node:	<%s> Client would like to prove a data exists\n", client)
+	logf(logDebug, "Node:	<%s> The Block Hash is %x, Leaf Hash is %x\n", client, request.BlockHash, request.LeafHash)
+
+	// Search in Local Blockchain for (1) Block Exist & (2) Local Blockchain has its data. Build the branch if both are true.
+	for i := 0; i < len(selfNodeChain.Blocks); i++ {
+		block := selfNodeChain.Blocks[i]
+		if string(block.CurrBlockHash) == string(request.BlockHash) && len(block.Data) > 0 {
+			index := n.FindLeaf(block.Data, request.LeafHash)
+			if index >= 0 {
+				proof = n.GenerateProof(block.Data, index)
+				proof.Header = block.CopyHeader()
+				proof.Height = i
+				logf(logDebug, "Node:	<%s> Target Data is found in local Blockchain\n", client)
+			}
+			break
+		}
+	}
+
+	// Search in Full Node in case it is not found in local blockchain.
+	if selfNodeChain.UserID != fullNodePort && proof == nil {
+
+		fullNodeConn, err := net.DialTimeout("tcp", fullNodeHost+":"+fullNodePort, peerDialTimeout)
+		logf(logDebug, "Node:	<%s> Target Data is not found in local Blockchain, now search in Full Node\n", client)
+
+		// Step 1:	Send "getPF" to Full Node
+		// Step 2:	Receive the proof if it is in Full Node.
+		if err == nil {
+			fullNodeConn.SetDeadline(time.Now().Add(peerTimeout))
+			if writeMsg(fullNodeConn, "getPF", payload) == nil {
+				if _, buf, err := readMsg(fullNodeConn); err == nil {
+					_ = json.Unmarshal(buf, &proof)
+				}
+			}
+			fullNodeConn.Close()
+		}
+		if proof != nil {
+			logf(logDebug, "Node:	<%s> Target Data is found in Full Node Blockchain\n", client)
+		}
+	}
+
+	if proof == nil {
+		logf(logDebug, "Node:	<%s> No result\n", client)
+	}
+	return proof
+}
+
+// handleLocate : Find the main chain block containing the data of leaf hash in payload, and build its Merkle branch.
+//				  Full Node is asked if the data is not found, e.g. the block is header only in this node.
+func handleLocate(payload []byte, client string, selfNodeChain Blockchain) *MerkleProof {
+
+	var proof *MerkleProof
+	var n Node
+
+	logf(logDebug, "Node:	<%s> Client would like to find the block of Leaf Hash %x\n", client, payload)
+	if block, index := LoadBlockByLeaf(payload, selfNodeChain.UserID); block != nil {
+		proof = n.GenerateProof(block.Data, index)
+		proof.Header = block.CopyHeader()
+		proof.Height = block.Height
+		logf(logDebug, "Node:	<%s> Target Data is found in Block %x\n", client, block.CurrBlockHash)
+	}
+
+	// Search in Full Node in case it is not found in local blockchain.
+	if selfNodeChain.UserID != fullNodePort && proof == nil {
+
+		fullNodeConn, err := net.DialTimeout("tcp", fullNodeHost+":"+fullNodePort, peerDialTimeout)
+		logf(logDebug, "Node:	<%s> Target Data is not found in local Blockchain, now search in Full Node\n", client)
+
+		if err == nil {
+			fullNodeConn.SetDeadline(time.Now().Add(peerTimeout))
+			if writeMsg(fullNodeConn, "getLF", payload) == nil {
+				if _, buf, err := readMsg(fullNodeConn); err == nil {
+					_ = json.Unmarshal(buf, &proof)
+				}
+			}
+			fullNodeConn.Close()
+		}
+	}
+
+	if proof == nil {
+		logf(logDebug, "Node:	<%s> No result\n", client)
+	}
+	return proof
+}
+
+func handleUTXO(payload []byte, conn net.Conn, selfNodeChain Blockchain) []*UTXOEntry {
+
+	logf(logDebug, "Node:	<%s> Client would like to retrive unspent outputs of %x\n", conn.RemoteAddr().String(), payload)
+	return GetUTXOSet(selfNodeChain.UserID).FindByAddress(payload)
+}
+
+func handleSubmit(payload []byte, client string, selfNodeChain Blockchain) []byte {
+
+	if _, err := submitTransaction(payload, client, selfNodeChain.UserID); err != nil {
+		return []byte("Fail    - " + err.Error())
+	}
+	return []byte("Success - Transaction is added to Mempool.")
+}
+
+// submitTransaction : Add a transaction submitted by client to Mempool, then announce it to peers.
+//						Return Transaction.Hash, or the reason if it is rejected.
+func submitTransaction(payload []byte, client string, userID string) ([]byte, error) {
+
+	logf(logDebug, "Node:	<%s> Client would like to submit a transaction\n", client)
+	err := GetMempool(userID).Add(payload, GetUTXOSet(userID))
+	if err != nil {
+		logf(logError, "Node:	<%s> Transaction is rejected, %s\n", client, err)
+		return nil, err
+	}
+	logf(logInfo, "Node:	<%s> Transaction is added to Mempool\n", client)
+
+	// Mempool has accepted the payload, so it is a valid Transaction.
+	tx, err := DeserializeTransaction(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	// Announce the transaction to peers, so that miners connected to other nodes could pack it too.
+	GetPeerManager(userID).Announce("tx", [][]byte{tx.Hash()}, "")
+	return tx.Hash(), nil
+}
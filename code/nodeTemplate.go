@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"math/bits"
+	"sort"
+	"time"
+)
+
+// BlockTemplate : Everything a miner needs to mine a block, chosen by the node. Reply of "getTM".
+//
+//	Miner only changes Timestamp (within MinTime and MaxTime), ExtraNonce of coinbase and Nonce.
+type BlockTemplate struct {
+	PrevBlockHash []byte
+	Height        int
+	Bits          uint32
+	Target        []byte
+	// Timestamp bounds. MinTime is median time past + 1, MaxTime is current time + maxFutureBlockTime
+	MinTime uint32
+	MaxTime uint32
+	CurTime uint32
+	// Data[0] is the coinbase which pays subsidy + fees to the miner. Root is the Merkle Tree Root of Data.
+	Data [][]byte
+	Root []byte
+	Fees uint64
+}
+
+// coinbaseReservedSize : Space (in byte) of Block.Data kept for the coinbase when transactions are selected
+const coinbaseReservedSize = 1024
+
+// CreateTemplate : Build a BlockTemplate on top of the tip. Pending transactions in Mempool are chosen by SelectTransactions,
+//					within maxBlockDataSize (the coinbase included). Coinbase pays subsidy + fees to minerAddress.
+func (bc *Blockchain) CreateTemplate(minerAddress []byte) (*BlockTemplate, error) {
+
+	if len(bc.Blocks) == 0 {
+		return nil, fmt.Errorf("blockchain is empty")
+	}
+	if len(minerAddress) != 32 {
+		return nil, fmt.Errorf("invalid miner address")
+	}
+
+	tip := bc.Blocks[len(bc.Blocks)-1]
+	height := len(bc.Blocks)
+	now := uint32(time.Now().Unix())
+	tmpl := &BlockTemplate{
+		PrevBlockHash: tip.CurrBlockHash,
+		Height:        height,
+		Bits:          bc.NextBits(),
+		MinTime:       bc.MedianTimePast() + 1,
+		MaxTime:       now + maxFutureBlockTime,
+		CurTime:       now,
+	}
+	tmpl.Target = CalTarget(tmpl.Bits).FillBytes(make([]byte, 32))
+	if tmpl.CurTime < tmpl.MinTime {
+		tmpl.CurTime = tmpl.MinTime
+	}
+
+	// Pack pending transactions paying the highest fee per byte. Skip transactions which are no longer valid on top of the tip.
+	data, fees := SelectTransactions(GetMempool(bc.UserID).Entries(), maxBlockDataSize-coinbaseReservedSize, GetUTXOSet(bc.UserID).Clone())
+	tmpl.Fees = fees
+
+	coinbase := CreateCoinbase(minerAddress, CalSubsidy(height)+tmpl.Fees, height, 0)
+	tmpl.Data = append([][]byte{coinbase.Serialize()}, data...)
+	tmpl.Root = CalRoot(tmpl.Data)
+	return tmpl, nil
+}
+
+// PrepareBlock : Create new Block from the template without Proof of Work, i.e. Nonce is not calculated yet.
+//				  Merkle Tree Root is calculated again from the selected Data.
+func (tmpl *BlockTemplate) PrepareBlock() *Block {
+	block := &Block{
+		Timestamp:     tmpl.CurTime,
+		PrevBlockHash: tmpl.PrevBlockHash,
+		Bits:          tmpl.Bits,
+		Data:          tmpl.Data,
+	}
+	block.SetExtraNonce(0)
+	return block
+}
+
+// SelectTransactions : Choose pending transactions maximizing the total fee within maxSize bytes. Return the data and total fee.
+//
+//	Transactions are sorted by fee per byte (the oldest first if equal), then added greedily.
+//	A transaction which does not fit, or is not valid on top of utxo, is skipped, so that smaller ones could still fill the block.
+//	utxo is changed, so a clone should be given.
+func SelectTransactions(entries []*MempoolEntry, maxSize int, utxo *UTXOSet) ([][]byte, uint64) {
+
+	sorted := make([]*MempoolEntry, len(entries))
+	copy(sorted, entries)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return compareFeeRate(sorted[i], sorted[j]) > 0
+	})
+
+	var data [][]byte
+	var dataSize int
+	var fees uint64
+	for i := 0; i < len(sorted); i++ {
+		if dataSize+len(sorted[i].Data) > maxSize {
+			continue
+		}
+		tx, err := DeserializeTransaction(sorted[i].Data)
+		if err != nil {
+			continue
+		}
+		fee, err := utxo.CheckTransaction(tx)
+		if err != nil {
+			continue
+		}
+		utxo.SpendTransaction(tx)
+		data = append(data, sorted[i].Data)
+		dataSize = dataSize + len(sorted[i].Data)
+		fees = fees + fee
+	}
+	return data, fees
+}
+
+// compareFeeRate : Compare fee per byte of two pending transactions, i.e. a.Fee / len(a.Data) against b.Fee / len(b.Data).
+//					Return 1 if a pays more, -1 if b pays more, 0 if equal. Compared in 128-bit to avoid overflow.
+func compareFeeRate(a, b *MempoolEntry) int {
+	aHi, aLo := bits.Mul64(a.Fee, uint64(len(b.Data)))
+	bHi, bLo := bits.Mul64(b.Fee, uint64(len(a.Data)))
+	switch {
+	case aHi > bHi || (aHi == bHi && aLo > bLo):
+		return 1
+	case aHi < bHi || (aHi == bHi && aLo < bLo):
+		return -1
+	}
+	return 0
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Command Mode : Every function of the menu could be run by a command, so that nodes and miners could be scripted.
+//
+//	Exit code is 0 if the command succeeds, 1 if it fails, 2 if the command or a flag is wrong.
+const cliUsage = `Usage: %s <command> [arguments] [flags]
+
+Commands:
+  node serve                      Start acting as a server (menu 10 -> 12)
+  node show                       Show Blockchain in Local Database (menu 10 -> 11)
+  miner mine [--data TXS]         Submit transactions in TXS if given, then mine a block (menu 20 -> 21)
+  miner get-chain                 Retrive all Block Hashes at server node (menu 20 -> 22)
+  miner get-block <hash>          Retrive block in blockchain using a block hash (menu 20 -> 23)
+  miner get-tx <root>             Retrive data in blockchain using a Merkle Tree Root (menu 20 -> 24)
+  miner get-height <height>       Retrive block in blockchain using a height (menu 20 -> 28)
+  miner get-range <from> <to>     Retrive blocks at heights from..to, headers only if --headers (menu 20 -> 29)
+  miner prove [<hash>] <tx|data>  Prove a data is in a block using a Merkle Proof (menu 20 -> 25).
+                                  The block is found by the node if <hash> is not given.
+  miner submit --data TXS         Submit transactions to Mempool of server node (menu 20 -> 26)
+  miner mempool                   Retrive pending transactions in Mempool of server node (menu 20 -> 27)
+  merkle root <data>[,<data>...]  Calculate a Merkle Tree Root (menu 30)
+  migrate                         Import JSON database of previous version into Block Store
+                                  Databases of the first version (no Bits, no coinbase) cannot be migrated
+  help                            Show this message
+
+TXS is in format of recipient:amount[:fee] (seperated by ','). Transactions are signed by the wallet of --port.
+Run without command to use the menu.
+
+Settings are read from ./config.json (or --config / CONFIG_FILE) if it exists, then from environment variables.
+Flags override both. Defaults below include the config file and environment variables.
+
+Flags:
+`
+
+// isCommand : Check if arg is a command of Command Mode
+func isCommand(arg string) bool {
+	switch arg {
+	case "node", "miner", "merkle", "migrate", "help", "-h", "-help", "--help":
+		return true
+	}
+	return false
+}
+
+// runCommand : Run a command of Command Mode, e.g. ["node", "serve", "--port", "7001"]. Return the exit code.
+func runCommand(args []string) int {
+
+	// Step 1 : Load config file and environment variables. They are the defaults of flags.
+	cfg, err := LoadConfig(cliConfigPath(args))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:	", err)
+		return 2
+	}
+
+	// Step 2 : Define flags
+	var configPath, peers, data string
+	var headerOnly bool
+	fs := flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), cliUsage, os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.StringVar(&configPath, "config", "", "config file in JSON, see config.example.json (default \""+configFile+"\" if it exists)")
+	fs.StringVar(&cfg.Host, "host", cfg.Host, "host of this node")
+	fs.StringVar(&cfg.Port, "port", cfg.Port, "userID of this node / miner, i.e. the port it listens on, and the name of its wallet")
+	fs.StringVar(&cfg.ServerHost, "server-host", cfg.ServerHost, "host of the node to connect")
+	fs.StringVar(&cfg.ServerPort, "server-port", cfg.ServerPort, "port of the node to connect")
+	fs.StringVar(&peers, "peers", strings.Join(cfg.Peers, ","), "seeds in format of host:port (seperated by ',')")
+	fs.StringVar(&cfg.FullNodeHost, "full-host", cfg.FullNodeHost, "host of Full Node")
+	fs.StringVar(&cfg.FullNodePort, "full-port", cfg.FullNodePort, "port of Full Node")
+	fs.StringVar(&cfg.DataDir, "datadir", cfg.DataDir, "directory of Local Database, wallets and address books")
+	fs.StringVar(&cfg.InitialBits, "difficulty", cfg.InitialBits, "Bits of Genesis Block in hexadecimal. Nodes using different Bits are on different chains")
+	fs.IntVar(&cfg.RetargetInterval, "retarget-interval", cfg.RetargetInterval, "number of blocks between two difficulty adjustments")
+	fs.IntVar(&cfg.TargetBlockTime, "target-block-time", cfg.TargetBlockTime, "expected time (in second) between two blocks")
+	fs.IntVar(&cfg.MaxBlockSize, "max-block-size", cfg.MaxBlockSize, "maximum total size (in byte) of data in a block")
+	fs.IntVar(&cfg.BlockSubsidy, "subsidy", cfg.BlockSubsidy, "reward of a block before the first halving, 0 means blocks pay fees only")
+	fs.IntVar(&cfg.HalvingInterval, "halving-interval", cfg.HalvingInterval, "number of blocks between two halvings of the reward")
+	fs.IntVar(&cfg.MinerThreads, "threads", cfg.MinerThreads, "number of goroutines used in Proof of Work, 0 means the number of CPU")
+	fs.StringVar(&cfg.LogLevel, "log-level", cfg.LogLevel, "error, info or debug")
+	fs.StringVar(&cfg.HTTPAddr, "http", cfg.HTTPAddr, "listen address of HTTP API and Web Explorer of node serve, e.g. localhost:8080 (disabled if empty)")
+	fs.StringVar(&data, "data", "", "transactions in format of recipient:amount[:fee] (seperated by ',')")
+	fs.BoolVar(&headerOnly, "headers", false, "retrive block headers only in get-range")
+
+	// Step 3 : Parse flags. Flags could be placed before or after the arguments.
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			if err == flag.ErrHelp {
+				return 0
+			}
+			return 2
+		}
+		args = fs.Args()
+		if len(args) == 0 {
+			break
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+	if len(positional) == 0 || positional[0] == "help" || positional[0] == "-h" || positional[0] == "-help" || positional[0] == "--help" {
+		fs.Usage()
+		return 0
+	}
+
+	// Step 4 : Apply settings
+	cfg.Peers = strings.Split(peers, ",")
+	if err = cfg.Apply(); err != nil {
+		fmt.Fprintln(os.Stderr, "Error:	", err)
+		return 2
+	}
+	userAddr, err := net.ResolveTCPAddr("tcp", cfg.Host+":"+cfg.Port)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:	", err)
+		return 2
+	}
+	serverAddr, err := net.ResolveTCPAddr("tcp", cfg.ServerHost+":"+cfg.ServerPort)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:	", err)
+		return 2
+	}
+
+	// Step 5 : Run the command
+	command := strings.Join(positional[:cliWords(positional)], " ")
+	arguments := positional[cliWords(positional):]
+	switch {
+
+	case command == "node serve" && len(arguments) == 0:
+		nodeServe(userAddr, serverAddr, cfg.Port)
+		return 1
+
+	case command == "node show" && len(arguments) == 0:
+		return cliExitCode(nodeShow(cfg.Port))
+
+	case command == "miner mine" && len(arguments) == 0:
+		if data != "" {
+			minerSubmit(serverAddr, minerGetDataFromArgs(cfg.Port, serverAddr, data))
+		}
+		return cliExitCode(minerMine(cfg.Port, serverAddr))
+
+	case command == "miner get-chain" && len(arguments) == 0:
+		return cliExitCode(minerShowChain(serverAddr))
+
+	case command == "miner get-block" && len(arguments) == 1:
+		blockHash, err := hex.DecodeString(arguments[0])
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error:	Block Hash is not in hexadecimal")
+			return 2
+		}
+		return cliExitCode(minerShowBlock(serverAddr, blockHash))
+
+	case command == "miner get-tx" && len(arguments) == 1:
+		root, err := hex.DecodeString(arguments[0])
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error:	Merkle Tree Root is not in hexadecimal")
+			return 2
+		}
+		return cliExitCode(minerShowData(serverAddr, root))
+
+	case command == "miner get-height" && len(arguments) == 1:
+		height, err := strconv.Atoi(arguments[0])
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error:	Height is not a number")
+			return 2
+		}
+		return cliExitCode(minerShowHeight(serverAddr, height))
+
+	case command == "miner get-range" && len(arguments) == 2:
+		from, err := strconv.Atoi(arguments[0])
+		to, err2 := strconv.Atoi(arguments[1])
+		if err != nil || err2 != nil {
+			fmt.Fprintln(os.Stderr, "Error:	Height is not a number")
+			return 2
+		}
+		return cliExitCode(minerShowRange(serverAddr, from, to, headerOnly))
+
+	case command == "miner prove" && len(arguments) == 1:
+		return cliExitCode(minerShowProof(serverAddr, nil, arguments[0]))
+
+	case command == "miner prove" && len(arguments) == 2:
+		blockHash, err := hex.DecodeString(arguments[0])
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error:	Block Hash is not in hexadecimal")
+			return 2
+		}
+		return cliExitCode(minerShowProof(serverAddr, blockHash, arguments[1]))
+
+	case command == "miner submit" && len(arguments) == 0 && data != "":
+		dataToSubmit := minerGetDataFromArgs(cfg.Port, serverAddr, data)
+		return cliExitCode(len(dataToSubmit) > 0 && minerSubmit(serverAddr, dataToSubmit) == len(dataToSubmit))
+
+	case command == "miner mempool" && len(arguments) == 0:
+		minerShowMempool(serverAddr)
+		return 0
+
+	case command == "merkle root" && len(arguments) > 0:
+		// Data could be seperated by ',' or given as separate arguments
+		if len(arguments) == 1 {
+			arguments = strings.Split(arguments[0], ",")
+		}
+		treeShowRoot(arguments)
+		return 0
+
+	case command == "migrate" && len(arguments) <= 1:
+		// userID could be given as an argument, as in previous version
+		if len(arguments) == 1 {
+			cfg.Port = arguments[0]
+		}
+		if err := MigrateJSON(cfg.Port); err != nil {
+			fmt.Fprintln(os.Stderr, "Error:	Cannot migrate JSON database,", err)
+			return 1
+		}
+		return 0
+
+	}
+
+	fmt.Fprintf(os.Stderr, "Error:	Unknown command or wrong arguments: %s\n\n", strings.Join(positional, " "))
+	fs.Usage()
+	return 2
+}
+
+// cliConfigPath : Find the value of "--config" in args before flags are parsed, because the config file gives the defaults of flags
+func cliConfigPath(args []string) string {
+	for i := 0; i < len(args); i++ {
+		name := strings.TrimLeft(args[i], "-")
+		if !strings.HasPrefix(args[i], "-") {
+			continue
+		}
+		if name == "config" && i+1 < len(args) {
+			return args[i+1]
+		}
+		if strings.HasPrefix(name, "config=") {
+			return strings.TrimPrefix(name, "config=")
+		}
+	}
+	return ""
+}
+
+// cliWords : Number of words of the command in positional arguments, e.g. 2 for "miner mine", 1 for "migrate"
+func cliWords(positional []string) int {
+	if positional[0] == "migrate" || len(positional) < 2 {
+		return 1
+	}
+	return 2
+}
+
+// cliExitCode : Exit code of a command, 0 if it succeeds, otherwise 1
+func cliExitCode(successFlag bool) int {
+	if successFlag {
+		return 0
+	}
+	return 1
+}
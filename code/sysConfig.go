@@ -0,0 +1,196 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"runtime"
+	"strings"
+)
+
+// Config : Settings of a node / miner, loaded from a JSON config file at startup (see config.example.json).
+//			Missing fields keep their default values.
+//
+//	Every setting could be overridden by an environment variable, then by a flag of Command Mode.
+//	i.e. flag > environment variable > config file > default.
+type Config struct {
+	// Listen address of this node. Port is also the userID, i.e. the name of Local Database and wallet. (NODE_HOST, NODE_PORT)
+	Host string
+	Port string
+	// Node to connect. Miner sends requests to it, node uses it as a seed. (SERVER_HOST, SERVER_PORT)
+	ServerHost string
+	ServerPort string
+	// Seeds, in addition to Full Node, the server node and seed file. (PEERS, seperated by ',')
+	Peers []string
+	// Full Node (FULL_NODE_HOST, FULL_NODE_PORT)
+	FullNodeHost string
+	FullNodePort string
+	// Directory of Local Database, wallets and address books (DATA_DIR)
+	DataDir string
+	// Difficulty : Bits of Genesis Block in hexadecimal, number of blocks between two retargets, and expected time (in second)
+	// between two blocks. Nodes must use the same values to be on the same chain. (INITIAL_BITS, RETARGET_INTERVAL, TARGET_BLOCK_TIME)
+	InitialBits      string
+	RetargetInterval int
+	TargetBlockTime  int
+	// Maximum total size (in byte) of Block.Data (MAX_BLOCK_SIZE)
+	MaxBlockSize int
+	// Reward of a block before the first halving, 0 means blocks pay fees only, and number of blocks between two halvings.
+	// Nodes must use the same values to be on the same chain. (BLOCK_SUBSIDY, HALVING_INTERVAL)
+	BlockSubsidy    int
+	HalvingInterval int
+	// Number of worker goroutines used in Proof of Work, 0 means the number of CPU (MINER_THREADS)
+	MinerThreads int
+	// error, info or debug. See sysLog.go. (LOG_LEVEL)
+	LogLevel string
+	// Listen address of HTTP API and Web Explorer, e.g. "localhost:8080". Disabled if it is empty. See nodeHTTP.go. (HTTP_ADDR)
+	HTTPAddr string
+}
+
+// configFile : Default config file. It is optional, i.e. defaults are used if it does not exist.
+//				Another file could be given by CONFIG_FILE or "--config", then it must exist.
+const configFile = "./config.json"
+
+// configPeers : Seeds given by config file, environment variable or flag
+var configPeers []string
+
+// DefaultConfig : Return the default settings
+func DefaultConfig() *Config {
+	return &Config{
+		Host:             "localhost",
+		Port:             fullNodePort,
+		ServerHost:       "localhost",
+		ServerPort:       fullNodePort,
+		FullNodeHost:     fullNodeHost,
+		FullNodePort:     fullNodePort,
+		DataDir:          dataDir,
+		InitialBits:      fmt.Sprintf("%08x", initialBits),
+		RetargetInterval: retargetInterval,
+		TargetBlockTime:  targetBlockTime,
+		MaxBlockSize:     maxBlockDataSize,
+		BlockSubsidy:     int(blockSubsidy),
+		HalvingInterval:  halvingInterval,
+		MinerThreads:     0,
+		LogLevel:         logLevelNames[logLevel],
+		HTTPAddr:         httpAddr,
+	}
+}
+
+// LoadConfig : Load the config file on top of DefaultConfig, then override it by environment variables.
+//				If path is "", CONFIG_FILE is used, otherwise ./config.json is used if it exists.
+func LoadConfig(path string) (*Config, error) {
+
+	cfg := DefaultConfig()
+	if path == "" {
+		path = os.Getenv("CONFIG_FILE")
+	}
+	if path == "" {
+		if _, err := os.Stat(configFile); err == nil {
+			path = configFile
+		}
+	}
+
+	// Fields in the file replace the defaults, missing fields are not changed
+	if path != "" {
+		jsonReader, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read config file, %s", err)
+		}
+		if err = json.Unmarshal(jsonReader, cfg); err != nil {
+			return nil, fmt.Errorf("cannot read config file %s, %s", path, err)
+		}
+	}
+
+	cfg.Host = envString("NODE_HOST", cfg.Host)
+	cfg.Port = envString("NODE_PORT", cfg.Port)
+	cfg.ServerHost = envString("SERVER_HOST", cfg.ServerHost)
+	cfg.ServerPort = envString("SERVER_PORT", cfg.ServerPort)
+	if peers := os.Getenv("PEERS"); peers != "" {
+		cfg.Peers = strings.Split(peers, ",")
+	}
+	cfg.FullNodeHost = envString("FULL_NODE_HOST", cfg.FullNodeHost)
+	cfg.FullNodePort = envString("FULL_NODE_PORT", cfg.FullNodePort)
+	cfg.DataDir = envString("DATA_DIR", cfg.DataDir)
+	cfg.InitialBits = envString("INITIAL_BITS", cfg.InitialBits)
+	cfg.LogLevel = envString("LOG_LEVEL", cfg.LogLevel)
+	cfg.HTTPAddr = envString("HTTP_ADDR", cfg.HTTPAddr)
+
+	// Integers must be valid if they are set, so that a typo is not silently ignored. 0 is allowed where it has a meaning.
+	settings := []struct {
+		name  string
+		value *int
+		min   int
+	}{
+		{"RETARGET_INTERVAL", &cfg.RetargetInterval, 1},
+		{"TARGET_BLOCK_TIME", &cfg.TargetBlockTime, 1},
+		{"MAX_BLOCK_SIZE", &cfg.MaxBlockSize, 1},
+		{"BLOCK_SUBSIDY", &cfg.BlockSubsidy, 0},
+		{"HALVING_INTERVAL", &cfg.HalvingInterval, 1},
+		{"MINER_THREADS", &cfg.MinerThreads, 0},
+	}
+	for _, setting := range settings {
+		value, err := readEnvInt(setting.name, *setting.value, setting.min)
+		if err != nil {
+			return nil, err
+		}
+		*setting.value = value
+	}
+	return cfg, nil
+}
+
+// Apply : Check the settings, then use them in this process. Nothing is changed if a setting is invalid.
+func (cfg *Config) Apply() error {
+
+	bits, err := ParseBits(cfg.InitialBits)
+	if err != nil {
+		return err
+	}
+	level, err := ParseLogLevel(cfg.LogLevel)
+	if err != nil {
+		return err
+	}
+	if cfg.RetargetInterval < 2 {
+		return fmt.Errorf("RetargetInterval must be at least 2")
+	}
+	if cfg.TargetBlockTime < 1 {
+		return fmt.Errorf("TargetBlockTime must be at least 1")
+	}
+	if cfg.MaxBlockSize <= coinbaseReservedSize {
+		return fmt.Errorf("MaxBlockSize must be more than %d, which is kept for the coinbase", coinbaseReservedSize)
+	}
+	if cfg.BlockSubsidy < 0 {
+		return fmt.Errorf("BlockSubsidy must not be negative")
+	}
+	if cfg.HalvingInterval < 1 {
+		return fmt.Errorf("HalvingInterval must be at least 1")
+	}
+	if cfg.MinerThreads < 0 {
+		return fmt.Errorf("MinerThreads must not be negative")
+	}
+	if cfg.DataDir == "" || cfg.Port == "" || cfg.ServerPort == "" || cfg.FullNodePort == "" {
+		return fmt.Errorf("DataDir, Port, ServerPort and FullNodePort must not be empty")
+	}
+
+	initialBits = bits
+	retargetInterval = cfg.RetargetInterval
+	targetBlockTime = cfg.TargetBlockTime
+	maxBlockDataSize = cfg.MaxBlockSize
+	blockSubsidy = uint64(cfg.BlockSubsidy)
+	halvingInterval = cfg.HalvingInterval
+	minerThreads = cfg.MinerThreads
+	if minerThreads == 0 {
+		minerThreads = runtime.NumCPU()
+	}
+	logLevel = level
+	dataDir = cfg.DataDir
+	fullNodeHost = cfg.FullNodeHost
+	fullNodePort = cfg.FullNodePort
+	httpAddr = cfg.HTTPAddr
+	configPeers = nil
+	for i := 0; i < len(cfg.Peers); i++ {
+		if peer := strings.TrimSpace(cfg.Peers[i]); peer != "" {
+			configPeers = append(configPeers, peer)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadConfigEnvInt(t *testing.T) {
+
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := ioutil.WriteFile(path, []byte("{}"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("RETARGET_INTERVAL", "20")
+	cfg, err := LoadConfig(path)
+	if err != nil || cfg.RetargetInterval != 20 {
+		t.Fatalf("LoadConfig returns %v, %v", cfg, err)
+	}
+
+	tests := []struct {
+		name  string
+		value string
+	}{
+		{"RETARGET_INTERVAL", "abc"},
+		{"TARGET_BLOCK_TIME", "-1"},
+		{"MAX_BLOCK_SIZE", "0"},
+		{"HALVING_INTERVAL", "1x"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Setenv(test.name, test.value)
+			if _, err := LoadConfig(path); !matchError(err, test.name) {
+				t.Errorf("LoadConfig with %s=%s returns %v", test.name, test.value, err)
+			}
+		})
+	}
+}
+
+func TestLoadConfigReward(t *testing.T) {
+
+	oldSubsidy, oldHalving, oldThreads := blockSubsidy, halvingInterval, minerThreads
+	defer func() { blockSubsidy, halvingInterval, minerThreads = oldSubsidy, oldHalving, oldThreads }()
+
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := ioutil.WriteFile(path, []byte(`{"BlockSubsidy": 10, "HalvingInterval": 20, "MinerThreads": 3}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Config file, then environment variables. 0 is a valid subsidy and number of threads.
+	t.Setenv("BLOCK_SUBSIDY", "0")
+	t.Setenv("MINER_THREADS", "0")
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.BlockSubsidy != 0 || cfg.HalvingInterval != 20 || cfg.MinerThreads != 0 {
+		t.Fatalf("LoadConfig returns BlockSubsidy %d, HalvingInterval %d, MinerThreads %d", cfg.BlockSubsidy, cfg.HalvingInterval, cfg.MinerThreads)
+	}
+	if err = cfg.Apply(); err != nil {
+		t.Fatal(err)
+	}
+	if CalSubsidy(1) != 0 || halvingInterval != 20 || minerThreads < 1 {
+		t.Errorf("Apply sets subsidy %d, halvingInterval %d, minerThreads %d", CalSubsidy(1), halvingInterval, minerThreads)
+	}
+
+	// A halving interval of 0 has no meaning
+	t.Setenv("HALVING_INTERVAL", "0")
+	if _, err = LoadConfig(path); !matchError(err, "HALVING_INTERVAL") {
+		t.Errorf("LoadConfig with HALVING_INTERVAL=0 returns %v", err)
+	}
+}
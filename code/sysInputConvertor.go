@@ -1,5 +1,11 @@
 package main
 
+import (
+	"fmt"
+	"os"
+	"strconv"
+)
+
 // This program is written by assuming array of strings is packed in block.
 // So need convertor to convert it to array of byte stream.
 // Affected:
@@ -14,3 +20,25 @@ func arrayConvertorStringToBytes(input []string) (output [][]byte) {
 	}
 	return output
 }
+
+// envString : Read a string from environment variable. Return defaultValue if it is not set.
+func envString(name string, defaultValue string) string {
+	value := os.Getenv(name)
+	if value == "" {
+		return defaultValue
+	}
+	return value
+}
+
+// readEnvInt : Read an integer which is at least min from environment variable. Return defaultValue if it is not set, or an error if it is invalid.
+func readEnvInt(name string, defaultValue int, min int) (int, error) {
+	text := os.Getenv(name)
+	if text == "" {
+		return defaultValue, nil
+	}
+	value, err := strconv.Atoi(text)
+	if err != nil || value < min {
+		return defaultValue, fmt.Errorf("%s must be an integer of at least %d, not %q", name, min, text)
+	}
+	return value, nil
+}
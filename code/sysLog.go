@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Log levels of a node. A message is printed if its level is not more than logLevel.
+//
+//	error	: Failures only, e.g. invalid blocks, unreachable peers, errors of Local Database
+//	info	: Changes of Blockchain, Mempool and peers
+//	debug	: Every request and reply, and the whole Blockchain after a new block is added
+const (
+	logError = iota
+	logInfo
+	logDebug
+)
+
+var logLevelNames = []string{"error", "info", "debug"}
+
+// logLevel : Current log level. It could be changed by "LogLevel" of config file, LOG_LEVEL, or "--log-level".
+var logLevel = logInfo
+
+// ParseLogLevel : Convert the name of a log level, e.g. "debug", to the log level
+func ParseLogLevel(name string) (int, error) {
+	for i := 0; i < len(logLevelNames); i++ {
+		if strings.EqualFold(name, logLevelNames[i]) {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("log level %s is not one of %s", name, strings.Join(logLevelNames, ", "))
+}
+
+// logf : Same as fmt.Printf, if level is enabled
+func logf(level int, format string, a ...interface{}) {
+	if level <= logLevel {
+		fmt.Printf(format, a...)
+	}
+}
+
+// logln : Same as fmt.Println, if level is enabled
+func logln(level int, a ...interface{}) {
+	if level <= logLevel {
+		fmt.Println(a...)
+	}
+}
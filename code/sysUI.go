@@ -1,19 +1,33 @@
 package main
 
 import (
-	"bytes"
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"net"
 	"os"
+	"strconv"
 	"strings"
 )
 
 func main() {
 
-	userHost := "localhost"
-	serverHost := "localhost"
+	// Command Mode, e.g. "node serve --port 7001". Nothing is read from standard input. See sysCLI.go.
+	if len(os.Args) >= 2 && isCommand(os.Args[1]) {
+		os.Exit(runCommand(os.Args[1:]))
+	}
+
+	// Otherwise settings are read from config file and environment variables. See sysConfig.go.
+	cfg, err := LoadConfig("")
+	if err == nil {
+		err = cfg.Apply()
+	}
+	if err != nil {
+		fmt.Println("Error:	", err)
+		os.Exit(2)
+	}
+	userHost := cfg.Host
+	serverHost := cfg.ServerHost
 	var userPort, serverPort string
 
 	if len(os.Args) == 3 {
@@ -31,14 +45,21 @@ func main() {
 		fmt.Println("> At least one Normal Node")
 		fmt.Println("> At least one Miner")
 		fmt.Println("> UserID of Normal Node/ Miner could any integer between 1025 and 65535")
+		fmt.Println("> Run with \"help\" to see commands which could be used in scripts")
 		fmt.Println()
 
-		// Set Port
-		fmt.Print("Please enter userID of you: ")
+		// Set Port. Press Enter to use the port in config file.
+		fmt.Printf("Please enter userID of you [%s]: ", cfg.Port)
 		fmt.Scanln(&userPort)
-		fmt.Print("Please enter userID of Node you wish to connect: ")
+		fmt.Printf("Please enter userID of Node you wish to connect [%s]: ", cfg.ServerPort)
 		fmt.Scanln(&serverPort)
 		fmt.Println()
+		if userPort == "" {
+			userPort = cfg.Port
+		}
+		if serverPort == "" {
+			serverPort = cfg.ServerPort
+		}
 
 	}
 	run(userHost, userPort, serverHost, serverPort)
@@ -53,10 +74,9 @@ func run(userHost string, userPort string, serverHost string, serverPort string)
 	errorMsg(err)
 
 	// Choose Function - Either be a nodecontroller, or a miner
-	// **Becauses Peer2Peer model (not Server & Client model) is need if a node is miner and nodecontroller at the same time.
-	// **Need to make the a TCP socket "Dial" and "Listen" in simultaneously.
-	// **By default, Peer2Peer socket is not supported in golang. Need to use external library.
-	fmt.Printf("Self Node port %s ; Server Node port %s ; (Full Node @ Port 9999 as a Server)\n", userPort, serverPort)
+	// Node listens and dials its peers at the same time, i.e. nodes form a Peer2Peer network. See nodePeer.go.
+	// Miner connects to one node only.
+	fmt.Printf("Self Node port %s ; Server Node port %s ; (Full Node @ Port %s as a Server)\n", userPort, serverPort, fullNodePort)
 	fmt.Printf("Enter 10 to become a Node\n")
 	fmt.Printf("Enter 20 to become a Miner\n")
 	fmt.Printf("Enter 30 to calculate a Merkle Tree Root\n")
@@ -73,46 +93,14 @@ func run(userHost string, userPort string, serverHost string, serverPort string)
 		switch input {
 
 		case "11" /*Node - Show local Blockchain*/ :
-			var selfNodeChain Blockchain
-			selfNodeChain.LoadFromLocalDB(userPort)
-			if len(selfNodeChain.Blocks) == 0 {
-				fmt.Println("Node:	No Data in Local Database")
-				break
-			}
-			fmt.Println("Node:	Blockchain at Local Database:")
-			selfNodeChain.PrintChain()
+			nodeShow(userPort)
 
 		case "12" /*Node - As a server*/ :
-
-			// Initialize by loading blockchain from Database
-			var selfNodeChain Blockchain
-			selfNodeChain.LoadFromDB(userPort)
-			if len(selfNodeChain.Blocks) == 0 {
-				fmt.Println("Node:	Error in loading blockchain. Exit")
-				break
-			}
-			fmt.Println("Node:	Blockchain at local Database:")
-			selfNodeChain.PrintChain()
-
-			// Listening from Miner
-			listener, err := net.ListenTCP("tcp", userAddr)
-			errorMsg(err)
-			fmt.Println("Node:	Server Listening on port", userPort)
-
-			// Create new socket if a connection is accepted
-			// golang allows multiple connection by default (non-blocking)
-			for {
-				conn, err := listener.Accept()
-				errorMsg(err)
-				go handleMsg(conn, selfNodeChain)
-			}
+			nodeServe(userAddr, serverAddr, userPort)
 
 		}
 
 	case "20" /* Miner Mode */ :
-		// Connect to server node
-		conn, err := net.DialTCP("tcp", userAddr, serverAddr)
-		errorMsg(err)
 		fmt.Printf("Miner:	Connection %s <--> %s\n", userAddr.String(), serverAddr.String())
 
 		// Choose Miner's action - either mining, or check transaction data
@@ -120,120 +108,383 @@ func run(userHost string, userPort string, serverHost string, serverPort string)
 		fmt.Println("- Enter 22 to Retrive all Block Hashes at server node")
 		fmt.Println("- Enter 23 to Retrive block in blockchain using a block hash")
 		fmt.Println("- Enter 24 to Retrive data  in blockchain using a Merkle Tree Root")
+		fmt.Println("- Enter 25 to Prove a data is in a block using a Merkle Proof")
+		fmt.Println("- Enter 26 to Submit transactions to Mempool of server node")
+		fmt.Println("- Enter 27 to Retrive pending transactions in Mempool of server node")
+		fmt.Println("- Enter 28 to Retrive block in blockchain using a height")
+		fmt.Println("- Enter 29 to Retrive block headers in a range of heights")
 		fmt.Scanln(&input)
 
 		switch input {
 
 		case "21" /*Miner - Mining*/ :
-			// Request PrevBlockHash
-			fmt.Println("Miner:	Request PrevBlockHash from Node")
-			message := []byte("addBK")
-			PrevBlockHashFromNode := minerSendMsg(conn, message)
-			fmt.Printf("Miner:	Received %x\n", PrevBlockHashFromNode)
-
-			// Get Data from user, and build a new Block
-			dataToPack := minerGetDataFromUI()
-			fmt.Println("Miner:	...mining...")
-			newBlock := CreateBlock(dataToPack, PrevBlockHashFromNode)
-			if newBlock.ValidateBlock() == true {
-				fmt.Println("Miner:	Success! Block information here:")
-				minerPrintBlock(newBlock)
-			}
-
-			// Serialize block using "encoding/json", then add the action indicator
-			fmt.Println("Miner:	Now send the Block to server node.")
-			newBlockJSON, _ := json.Marshal(newBlock)
-			message = bytes.Join([][]byte{[]byte("addBK"), newBlockJSON}, []byte{})
-			fmt.Println("Miner:	Result - ", string(minerSendMsg(conn, message)))
-
-			conn.Close()
-			break
+			minerMine(userPort, serverAddr)
 
 		case "22" /*Miner - Check Block Hashes*/ :
-			// Request BlockChain
-			fmt.Println("Miner:	Request Full Block Hashes from Node")
-			message := []byte("getBC")
-			blockHashesFromNode := minerSendMsg(conn, message)
-			fmt.Printf("Miner:	Received Block Hashes\n")
-			conn.Close()
-
-			// BlockChain is in JSON. Need to decode.
-			fmt.Printf("Miner:	...Decoding Block Hashes...\n")
-			var blockHashes Blockchain
-			err = json.Unmarshal(blockHashesFromNode, &blockHashes)
-
-			// Print BlockChain
-			fmt.Println("Miner:	Block Hashes from server Node")
-			blockHashes.PrintChain()
-			fmt.Println("Miner:	Is the Block Hashes valid? -", blockHashes.ValidateChain())
-			break
+			minerShowChain(serverAddr)
 
 		case "23" /*Miner - Check Single Block*/ :
-			// Request BlockChain
 			fmt.Print("Miner:	Please input the Block Hash here ")
 			fmt.Scanln(&input)
-			fmt.Printf("Miner:	Request the Block with Hashes %s\n", input)
-			message, _ := hex.DecodeString(input)
-			message = bytes.Join([][]byte{[]byte("getBK"), message}, []byte{})
-			targetBlockFromNode := minerSendMsg(conn, message)
-			fmt.Printf("Miner:	Received the Block\n")
-			conn.Close()
-
-			// BlockChain is in JSON. Need to decode.
-			fmt.Printf("Miner:	...Decoding the Block...\n")
-			var targetBlock Blockchain
-			err = json.Unmarshal(targetBlockFromNode, &targetBlock)
-
-			// Print BlockChain
-			if len(targetBlock.Blocks) > 0 {
-				fmt.Println("Miner:	Target Block is found")
-				targetBlock.PrintChain()
-			} else {
-				fmt.Println("Miner:	Target Block is not found")
-			}
-			break
+			blockHash, _ := hex.DecodeString(input)
+			minerShowBlock(serverAddr, blockHash)
 
 		case "24" /*Miner - Check Single Data*/ :
-			// Request BlockChain
 			fmt.Print("Miner:	Please input the Merkle Tree Root here ")
 			fmt.Scanln(&input)
-			fmt.Printf("Miner:	Request the Block with Merkle Tree Root %s\n", input)
-			message, _ := hex.DecodeString(input)
-			message = bytes.Join([][]byte{[]byte("getTX"), message}, []byte{})
-			targetBlockFromNode := minerSendMsg(conn, message)
-			fmt.Printf("Miner:	Received the Block\n")
-			conn.Close()
-
-			// BlockChain is in JSON. Need to decode.
-			fmt.Printf("Miner:	...Decoding the Block...\n")
-			var targetBlock Blockchain
-			err = json.Unmarshal(targetBlockFromNode, &targetBlock)
-
-			// Print BlockChain
-			if len(targetBlock.Blocks) > 0 {
-				fmt.Println("Miner:	Target Block is found")
-				targetBlock.PrintChain()
-			} else {
-				fmt.Println("Miner:	Target Block is not found")
+			root, _ := hex.DecodeString(input)
+			minerShowData(serverAddr, root)
+
+		case "25" /*Miner - Prove Single Data*/ :
+			fmt.Print("Miner:	Please input the Block Hash here (or \"any\" to search in the whole blockchain) ")
+			fmt.Scanln(&input)
+			var blockHash []byte
+			if input != "any" {
+				blockHash, _ = hex.DecodeString(input)
 			}
-			break
+			fmt.Print("Miner:	Please input the Transaction Hash (or the data) to be proved here ")
+			fmt.Scanln(&input)
+			minerShowProof(serverAddr, blockHash, input)
+
+		case "26" /*Miner - Submit Transactions*/ :
+			// Get Data from user, then submit them one by one
+			minerSubmit(serverAddr, minerGetDataFromUI(userPort, serverAddr))
+
+		case "27" /*Miner - Check Mempool*/ :
+			minerShowMempool(serverAddr)
+
+		case "28" /*Miner - Check Block at Height*/ :
+			fmt.Print("Miner:	Please input the Height here ")
+			fmt.Scanln(&input)
+			height, _ := strconv.Atoi(input)
+			minerShowHeight(serverAddr, height)
+
+		case "29" /*Miner - Check Block Headers in Range*/ :
+			var from, to int
+			fmt.Print("Miner:	Please input the first and the last Height here (seperated by space) ")
+			fmt.Scanln(&from, &to)
+			minerShowRange(serverAddr, from, to, true)
 		}
-		break
 
 	case "30" /*Calculated Merkle Tree Root*/ :
 		var dataRaw string
-		var dataString []string
-		var dataBytes [][]byte
 		fmt.Println("Tree:	Enter data to be used for Merkle Tree Calculation (seperated by ',')")
 		fmt.Scan(&dataRaw)
-		dataString = strings.Split(dataRaw, ",")
-		dataBytes = arrayConvertorStringToBytes(dataString)
-		CalTree(dataBytes)
-		fmt.Printf("\n")
-		fmt.Printf("Tree:	The Merkle Tree Root is %x\n", CalRoot(dataBytes))
-		break
+		treeShowRoot(strings.Split(dataRaw, ","))
+
+	}
+}
+
+// nodeShow : Print Blockchain in Local Database of userID. Return false if Local Database is empty.
+func nodeShow(userID string) bool {
+	var selfNodeChain Blockchain
+	selfNodeChain.LoadFromLocalDB(userID)
+	if len(selfNodeChain.Blocks) == 0 {
+		fmt.Println("Node:	No Data in Local Database")
+		return false
+	}
+	fmt.Println("Node:	Blockchain at Local Database:")
+	selfNodeChain.PrintChain()
+	return true
+}
+
+// nodeServe : Act as a server on userAddr. Return only if Blockchain could not be loaded.
+func nodeServe(userAddr *net.TCPAddr, serverAddr *net.TCPAddr, userID string) {
+
+	// Join the Peer2Peer network. Full Node, the server node, peers in config and in seed file are the first peers.
+	// Other peers are found in the address book and by "getaddr".
+	pm := GetPeerManager(userID)
+	pm.SetSelf(userAddr.String())
+	pm.AddSeed(fullNodeHost + ":" + fullNodePort)
+	pm.AddSeed(serverAddr.String())
+	for i := 0; i < len(configPeers); i++ {
+		pm.AddSeed(configPeers[i])
+	}
+	pm.LoadSeeds(seedFile)
+
+	// Initialize by loading blockchain from Database. The chain is kept in memory, and shared by all connections.
+	if _, err := GetBlockStore(userID); err != nil {
+		fmt.Println("Node:	Cannot open Local Database,", err)
+		return
+	}
+	state := NewChainState(userID)
+	selfNodeChain := state.Snapshot()
+	if len(selfNodeChain.Blocks) == 0 {
+		fmt.Println("Node:	Error in loading blockchain. Exit")
+		return
+	}
+	fmt.Println("Node:	Blockchain at local Database:")
+	selfNodeChain.PrintChain()
+
+	// Listening from Miner
+	listener, err := net.ListenTCP("tcp", userAddr)
+	errorMsg(err)
+	fmt.Println("Node:	Server Listening on port", userAddr.Port)
+	go pm.Hello()
+	go state.SyncLoop()
+	if httpAddr != "" {
+		go serveHTTP(httpAddr, state)
+	}
+
+	// Create new socket if a connection is accepted
+	// golang allows multiple connection by default (non-blocking)
+	for {
+		conn, err := listener.Accept()
+		errorMsg(err)
+		go handleMsg(conn, state)
+	}
+}
+
+// minerMine : Mine a block using the template of the server node. Reward is paid to the wallet of userID.
+//			   Return true if the block is stored by the server node.
+func minerMine(userID string, serverAddr *net.TCPAddr) bool {
+
+	// Request a block template. Reward of the block is paid to the wallet of miner
+	wallet := LoadWallet(userID)
+	if wallet == nil {
+		return false
+	}
+	conn, err := net.DialTCP("tcp", nil, serverAddr)
+	errorMsg(err)
+	fmt.Println("Miner:	Request Block Template from Node")
+	templateFromNode := minerSendMsg(conn, "getTM", CalAddress(&wallet.PublicKey))
+	conn.Close()
+	var tmpl *BlockTemplate
+	json.Unmarshal(templateFromNode, &tmpl)
+	if tmpl == nil {
+		fmt.Println("Miner:	Invalid reply from Node")
+		return false
+	}
+	fmt.Printf("Miner:	Received %x, Bits %08x, Height %d\n", tmpl.PrevBlockHash, tmpl.Bits, tmpl.Height)
+	fmt.Printf("Miner:	%d pending transaction(s) from Mempool, fees %d\n", len(tmpl.Data)-1, tmpl.Fees)
+	fmt.Printf("Miner:	Reward %d is paid to %x\n", CalSubsidy(tmpl.Height)+tmpl.Fees, CalAddress(&wallet.PublicKey))
+
+	// Build a new Block using the template
+	fmt.Printf("Miner:	...mining with %d thread(s)...\n", minerThreads)
+	newBlock := tmpl.PrepareBlock()
+
+	// Cancel mining if a new tip arrives, because the new block could not be added anymore.
+	cancel := make(chan struct{})
+	done := make(chan struct{})
+	go minerWatchTip(serverAddr, tmpl.PrevBlockHash, cancel, done)
+	foundFlag, stats := newBlock.CalNoncePOWRolling(minerThreads, cancel)
+	close(done)
+	fmt.Printf("Miner:	%d hashes in %s, hashrate %.0f H/s\n", stats.Hashes, stats.Duration, stats.Hashrate())
+	if foundFlag == false {
+		fmt.Println("Miner:	Mining is cancelled.")
+		return false
+	}
+	if newBlock.ValidateBlock() == true {
+		fmt.Println("Miner:	Success! Block information here:")
+		minerPrintBlock(newBlock)
+	}
+
+	// Serialize block using "encoding/json", then send it with the action indicator
+	fmt.Println("Miner:	Now send the Block to server node.")
+	conn, err = net.DialTCP("tcp", nil, serverAddr)
+	errorMsg(err)
+	newBlockJSON, _ := json.Marshal(newBlock)
+	var result BlockResult
+	replyFromNode := minerSendMsg(conn, "addBK", newBlockJSON)
+	conn.Close()
+	if json.Unmarshal(replyFromNode, &result) != nil {
+		fmt.Println("Miner:	Cannot read result from server node.")
+		return false
+	} else if result.Stored() {
+		fmt.Println("Miner:	Result - ", result.Status, "-", result.Detail)
+		return true
+	}
+	fmt.Println("Miner:	Result - ", result.Status, "- Block is rejected,", result.Detail)
+	return false
+}
+
+// minerShowChain : Print all block headers of the server node, and check if they are valid. Return the result of the check.
+func minerShowChain(serverAddr *net.TCPAddr) bool {
+
+	// Request BlockChain
+	conn, err := net.DialTCP("tcp", nil, serverAddr)
+	errorMsg(err)
+	fmt.Println("Miner:	Request Full Block Hashes from Node")
+	blockHashesFromNode := minerSendMsg(conn, "getBC", nil)
+	fmt.Printf("Miner:	Received Block Hashes\n")
+	conn.Close()
+
+	// BlockChain is in JSON. Need to decode.
+	fmt.Printf("Miner:	...Decoding Block Hashes...\n")
+	var blockHashes BlockReply
+	json.Unmarshal(blockHashesFromNode, &blockHashes)
+	blockHashes.SetHeights()
+
+	// Print BlockChain
+	fmt.Println("Miner:	Block Hashes from server Node")
+	blockHashes.PrintChain()
+	validFlag := blockHashes.ValidateChain()
+	fmt.Println("Miner:	Is the Block Hashes valid? -", validFlag)
+	return validFlag
+}
+
+// minerShowBlock : Print the block of blockHash at the server node. Return false if it is not found.
+func minerShowBlock(serverAddr *net.TCPAddr, blockHash []byte) bool {
+	fmt.Printf("Miner:	Request the Block with Hashes %x\n", blockHash)
+	return minerShowResult(serverAddr, "getBK", blockHash)
+}
+
+// minerShowData : Print the block whose Merkle Tree Root is root at the server node. Return false if it is not found.
+func minerShowData(serverAddr *net.TCPAddr, root []byte) bool {
+	fmt.Printf("Miner:	Request the Block with Merkle Tree Root %x\n", root)
+	return minerShowResult(serverAddr, "getTX", root)
+}
+
+// minerShowHeight : Print the block at height of main chain at the server node. Return false if it is not found.
+func minerShowHeight(serverAddr *net.TCPAddr, height int) bool {
+	fmt.Printf("Miner:	Request the Block at Height %d\n", height)
+	return minerShowResult(serverAddr, "getBH", []byte(strconv.Itoa(height)))
+}
+
+// minerShowRange : Print the blocks (or headers only) of main chain at heights from..to at the server node.
+//					The range is requested part by part, since a reply of "getBR" is limited. Return false if nothing is found.
+func minerShowRange(serverAddr *net.TCPAddr, from int, to int, headerOnly bool) bool {
+
+	var blocks Blockchain
+	fmt.Printf("Miner:	Request the Blocks at Height %d..%d\n", from, to)
+	for from <= to {
+		conn, err := net.DialTCP("tcp", nil, serverAddr)
+		if err != nil {
+			fmt.Println("Miner:	Cannot connect to node,", err)
+			return false
+		}
+		request, _ := json.Marshal(&RangeRequest{From: from, To: to, HeaderOnly: headerOnly})
+		reply := minerSendMsg(conn, "getBR", request)
+		conn.Close()
+
+		var part BlockReply
+		json.Unmarshal(reply, &part)
+		if len(part.Blocks) == 0 || len(part.Heights) != len(part.Blocks) {
+			break
+		}
+		part.SetHeights()
+		blocks.Blocks = append(blocks.Blocks, part.Blocks...)
+		if part.Heights[len(part.Heights)-1] < from {
+			break
+		}
+		from = part.Heights[len(part.Heights)-1] + 1
+	}
 
+	if len(blocks.Blocks) == 0 {
+		fmt.Println("Miner:	Target Blocks are not found")
+		return false
 	}
+	fmt.Printf("Miner:	%d Block(s) are found\n", len(blocks.Blocks))
+	blocks.PrintChain()
+	return true
+}
+
+// minerShowResult : Send a "getBK" / "getTX" / "getBH" request, then print the block in the reply. Return false if it is not found.
+func minerShowResult(serverAddr *net.TCPAddr, command string, id []byte) bool {
+
+	conn, err := net.DialTCP("tcp", nil, serverAddr)
+	errorMsg(err)
+	targetBlockFromNode := minerSendMsg(conn, command, id)
+	fmt.Printf("Miner:	Received the Block\n")
+	conn.Close()
+
+	// BlockChain is in JSON. Need to decode.
+	fmt.Printf("Miner:	...Decoding the Block...\n")
+	var targetBlock BlockReply
+	json.Unmarshal(targetBlockFromNode, &targetBlock)
+	targetBlock.SetHeights()
+
+	// Print BlockChain
+	if len(targetBlock.Blocks) == 0 {
+		fmt.Println("Miner:	Target Block is not found")
+		return false
+	}
+	fmt.Println("Miner:	Target Block is found")
+	targetBlock.PrintChain()
+	return true
+}
+
+// minerShowProof : Request the Merkle Proof of a data in the block of blockHash, then verify it locally.
+//					leaf is the Transaction Hash in hexadecimal, or the data itself. Return the result of the verification.
+//					If blockHash is empty, the block is found by the node using its leaf index ("getLF").
+func minerShowProof(serverAddr *net.TCPAddr, blockHash []byte, leaf string) bool {
+
+	// Request Merkle Proof
+	var request ProofRequest
+	var n Node
+	var err error
+	request.BlockHash = blockHash
+	request.LeafHash, err = hex.DecodeString(leaf)
+	if err != nil || len(request.LeafHash) != 32 {
+		request.LeafHash = n.CalSHA256Hash([]byte(leaf))
+	}
+	fmt.Printf("Miner:	Request the Merkle Proof of Leaf Hash %x\n", request.LeafHash)
+	conn, err := net.DialTCP("tcp", nil, serverAddr)
+	errorMsg(err)
+	var proofFromNode []byte
+	if len(blockHash) == 0 {
+		proofFromNode = minerSendMsg(conn, "getLF", request.LeafHash)
+	} else {
+		message, _ := json.Marshal(request)
+		proofFromNode = minerSendMsg(conn, "getPF", message)
+	}
+	fmt.Printf("Miner:	Received the Merkle Proof\n")
+	conn.Close()
+
+	// Merkle Proof is in JSON. Need to decode.
+	fmt.Printf("Miner:	...Decoding the Merkle Proof...\n")
+	var proof *MerkleProof
+	json.Unmarshal(proofFromNode, &proof)
+
+	// Verify Merkle Proof locally
+	if proof == nil {
+		fmt.Println("Miner:	Merkle Proof is not found")
+		return false
+	}
+	fmt.Println("Miner:	Merkle Proof is found")
+	proof.PrintProof()
+	if len(request.BlockHash) == 0 && proof.Header != nil {
+		request.BlockHash = proof.Header.CurrBlockHash
+	}
+	validFlag := proof.VerifyProof(request.BlockHash, request.LeafHash)
+	fmt.Println("Miner:	Is the data in the block? -", validFlag)
+	return validFlag
+}
+
+// minerSubmit : Submit transactions to Mempool of the server node one by one. Return the number of accepted transactions.
+func minerSubmit(serverAddr *net.TCPAddr, dataToSubmit [][]byte) int {
+	accepted := 0
+	for i := 0; i < len(dataToSubmit); i++ {
+		conn, err := net.DialTCP("tcp", nil, serverAddr)
+		errorMsg(err)
+		result := string(minerSendMsg(conn, "subTX", dataToSubmit[i]))
+		conn.Close()
+		fmt.Println("Miner:	Result - ", result)
+		if strings.HasPrefix(result, "Success") {
+			accepted++
+		}
+	}
+	return accepted
+}
+
+// minerShowMempool : Print pending transactions in Mempool of the server node
+func minerShowMempool(serverAddr *net.TCPAddr) {
+
+	// Request Mempool
+	fmt.Println("Miner:	Request pending transactions from Node")
+	pending := minerGetMempool(serverAddr)
+	fmt.Printf("Miner:	%d pending transaction(s) in Mempool\n", len(pending))
+	for i := 0; i < len(pending); i++ {
+		fmt.Printf("	> %x	Fee %d	%s\n", pending[i].TxHash, pending[i].Fee, pending[i].Data)
+	}
+}
+
+// treeShowRoot : Print the Merkle Tree and its root of data
+func treeShowRoot(dataString []string) {
+	dataBytes := arrayConvertorStringToBytes(dataString)
+	CalTree(dataBytes)
+	fmt.Printf("\n")
+	fmt.Printf("Tree:	The Merkle Tree Root is %x\n", CalRoot(dataBytes))
 }
 
 func errorMsg(err error) {